@@ -1,13 +1,16 @@
 package main
 
 import (
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cznic/sortutil"
-	"github.com/jmoiron/sqlx"
 	"github.com/mopsalarm/go-pr0gramm-tags/parser"
 	"github.com/mopsalarm/go-pr0gramm-tags/store"
+	"github.com/mopsalarm/go-pr0gramm-tags/tagsapi"
 	log "github.com/sirupsen/logrus"
 	"strings"
 )
@@ -35,15 +38,71 @@ func withLock(locker sync.Locker, fn func()) {
 	fn()
 }
 
+type searchConfig struct {
+	UseOptimizer bool
+
+	// Fraction of queries that are executed a second time in the background
+	// using the shadow rule set. Set to zero to disable shadow mode.
+	ShadowSampleRate float64
+
+	// Optimizer rules to use for the shadow execution. If this is nil,
+	// the shadow execution runs without any optimization.
+	ShadowRules []string
+}
+
 type storeActions struct {
 	Locker
-	UseOptimizer bool
-	updateLock   sync.Mutex
-	store        store.IterStore
-	storeState   store.StoreState
+
+	// Every writer of the store holds the update lock. While holding it,
+	// the store can be read without the read lock.
+	updateLock sync.Mutex
+	store      store.IterStore
+	storeState store.StoreState
+	dict       *store.Dictionary
+
+	// maps items to their keys, nil if the forward index is disabled.
+	forward *store.ForwardIndex
+
+	// keys of all item derived terms, guarded by the update lock.
+	derivedKeys map[uint32]bool
+
+	// ids of events that were already ingested.
+	ingested eventIdSet
+
+	// true, if events can be ingested. Ingested events are not part of the
+	// update source, a rebuild would drop them.
+	ingestEnabled bool
+
+	// source of the updates, used to rebuild the store. nil if updates are disabled.
+	source UpdateSource
+
+	configLock sync.Mutex
+	config     atomic.Value
+	shadow     *shadowRunner
+
+	jobs jobRunner
+}
+
+func (sa *storeActions) Config() searchConfig {
+	config, _ := sa.config.Load().(searchConfig)
+	return config
+}
+
+func (sa *storeActions) SetConfig(config searchConfig) {
+	sa.UpdateConfig(func(current *searchConfig) {
+		*current = config
+	})
+}
+
+func (sa *storeActions) UpdateConfig(fn func(config *searchConfig)) {
+	withLock(&sa.configLock, func() {
+		config := sa.Config()
+		fn(&config)
+		sa.config.Store(config)
+	})
 }
 
-func (sa *storeActions) UpdateOnce(db *sqlx.DB) bool {
+func (sa *storeActions) UpdateOnce(source UpdateSource) bool {
 	log.Debug("Looking for updates...")
 
 	var currentStoreState store.StoreState
@@ -52,70 +111,337 @@ func (sa *storeActions) UpdateOnce(db *sqlx.DB) bool {
 	})
 
 	queryStart := time.Now()
-	updates, newState, more := FetchUpdates(db, currentStoreState)
+	changes, err := source.FetchChanges(currentStoreState)
 	log.WithField("duration", time.Since(queryStart)).Debug("Looking for new updates finished")
 
+	if err != nil {
+		log.WithError(err).Warn("Could not fetch changes")
+		metricsUpdaterError.Inc(1)
+		return false
+	}
+
+	batch := buildUpdates(sa.dict, changes)
+	sa.applyUpdates(batch)
+	return batch.More
+}
+
+// Merges the updates into the store and removes updated items from
+// item derived keys they do not belong to anymore.
+func (sa *storeActions) applyUpdates(batch updateBatch) {
 	// allow only one update at a time
 	withLock(&sa.updateLock, func() {
-		log.WithField("keyCount", updates.KeyCount()).Debug("Will merge updates now")
-		metricsKeysCount.Update(int64(sa.store.KeyCount()))
+		sa.mergeUpdates(batch)
+	})
+}
 
-		// now merge the updates into the store...
-		start := time.Now()
+// Merges the updates into the store. Must be called while holding the update lock.
+func (sa *storeActions) mergeUpdates(batch updateBatch) {
+	updates := batch.Updates
+
+	log.WithField("keyCount", updates.KeyCount()).Debug("Will merge updates now")
+	metricsKeysCount.Update(int64(sa.store.KeyCount()))
+
+	// now merge the updates into the store...
+	start := time.Now()
+
+	// get the keys while holding the lock
+	changedKeyCount := int64(0)
+	for _, key := range updates.Keys() {
+		// get the values from both iterators
+		storeValues := store.IteratorToList(nil, sa.store.GetIterator(key))
+		updateValues := store.IteratorToList(nil, updates.GetIterator(key))
+
+		if sa.forward != nil {
+			sa.forward.Add(key, updateValues)
+		}
+
+		// an update is only required, if one of the updated values are not in the old values.
+		requireUpdate := false
+		notFound := len(storeValues)
+		for _, value := range updateValues {
+			idx := sortutil.SearchInt32s(storeValues, value)
+			if idx == notFound || storeValues[idx] != value {
+				requireUpdate = true
+				break
+			}
+		}
+
+		if requireUpdate {
+			changedKeyCount += 1
+
+			values := store.IteratorToList(nil, store.NewOrIterator(
+				store.NewSliceIterator(storeValues), store.NewSliceIterator(updateValues)))
+
+			sa.WithWriteLock(func() {
+				sa.store.Replace(key, values)
+			})
+		}
+	}
+
+	if changedKeyCount == 0 {
+		log.Debug("No updates were merged, state is up-to-date.")
+	}
 
-		// get the keys while holding the lock
-		changedKeyCount := int64(0)
-		for _, key := range updates.Keys() {
-			// get the values from both iterators
-			storeValues := store.IteratorToList(nil, sa.store.GetIterator(key))
-			updateValues := store.IteratorToList(nil, updates.GetIterator(key))
-
-			// an update is only required, if one of the updated values are not in the old values.
-			requireUpdate := false
-			notFound := len(storeValues)
-			for _, value := range updateValues {
-				idx := sortutil.SearchInt32s(storeValues, value)
-				if idx == notFound || storeValues[idx] != value {
-					requireUpdate = true
+	staleKeyCount := sa.removeStaleDerivedKeys(batch.ItemKeys)
+	staleKeyCount += sa.removeTerms(batch.RemovedTerms)
+
+	// purge deleted items after merging, they might have been updated too.
+	if len(batch.DeletedItems) > 0 {
+		staleKeyCount += sa.removeItems(batch.DeletedItems)
+		metricsUpdaterItemsPurged.Inc(int64(len(batch.DeletedItems)))
+	}
+
+	metricsUpdaterKeysChanged.Inc(changedKeyCount + int64(staleKeyCount))
+	sa.WithWriteLock(func() {
+		if batch.State != nil {
+			sa.storeState = *batch.State
+		}
+
+		log.WithField("duration", time.Since(start)).
+			WithField("keyCount", changedKeyCount).
+			WithField("staleKeyCount", staleKeyCount).
+			WithField("purgedItemCount", len(batch.DeletedItems)).
+			WithField("state", sa.storeState).
+			WithField("memory", sa.store.MemorySize()).
+			Info("Update finished and merged")
+	})
+}
+
+// Removes the updated items from the item derived keys that are not in their
+// new set of keys. Must be called while holding the update lock.
+// Returns the number of changed keys.
+func (sa *storeActions) removeStaleDerivedKeys(itemKeys map[int32][]uint32) int {
+	if len(itemKeys) == 0 {
+		return 0
+	}
+
+	if sa.derivedKeys == nil {
+		sa.derivedKeys = make(map[uint32]bool)
+		sa.dict.ForEach(func(term string, key uint32) {
+			if isItemDerived(term) {
+				sa.derivedKeys[key] = true
+			}
+		})
+	}
+
+	for _, keys := range itemKeys {
+		for _, key := range keys {
+			sa.derivedKeys[key] = true
+		}
+	}
+
+	staleItems := make(map[uint32][]int32)
+	if sa.forward != nil {
+		// the forward index knows the previous keys of each item.
+		for item, keys := range itemKeys {
+			for _, key := range sa.forward.Keys(item) {
+				if sa.derivedKeys[key] && !containsKey(keys, key) {
+					staleItems[key] = append(staleItems[key], item)
+				}
+			}
+		}
+
+	} else {
+		items := make([]int32, 0, len(itemKeys))
+		for item := range itemKeys {
+			items = append(items, item)
+		}
+
+		sort.Sort(sortutil.Int32Slice(items))
+
+		for key := range sa.derivedKeys {
+			// only writers hold the update lock, so it is safe to read without the read lock.
+			iter := sa.store.GetIterator(key)
+			for _, item := range items {
+				store.IteratorSkipUntil(iter, item)
+				if !iter.HasMore() {
 					break
 				}
+
+				if iter.Peek() == item && !containsKey(itemKeys[item], key) {
+					staleItems[key] = append(staleItems[key], item)
+				}
+			}
+		}
+	}
+
+	changedKeyCount := 0
+	for key, stale := range staleItems {
+		sort.Sort(sortutil.Int32Slice(stale))
+
+		sa.WithWriteLock(func() {
+			if sa.store.RemoveItems(key, stale) {
+				changedKeyCount++
 			}
+		})
 
-			if requireUpdate {
-				changedKeyCount += 1
+		if sa.forward != nil {
+			sa.forward.RemoveKey(key, stale)
+		}
+	}
 
-				values := store.IteratorToList(nil, store.NewOrIterator(
-					store.NewSliceIterator(storeValues), store.NewSliceIterator(updateValues)))
+	return changedKeyCount
+}
 
-				sa.WithWriteLock(func() {
-					sa.store.Replace(key, values)
-				})
+// Removes the items from the keys of the given terms. Must be called
+// while holding the update lock. Returns the number of changed keys.
+func (sa *storeActions) removeTerms(removedTerms map[int32][]string) int {
+	termCount := 0
+	itemsByKey := make(map[uint32][]int32)
+	for item, terms := range removedTerms {
+		termCount += len(terms)
+		for _, term := range terms {
+			if key, ok := sa.dict.Lookup(term); ok {
+				itemsByKey[key] = append(itemsByKey[key], item)
 			}
 		}
+	}
+
+	changedKeyCount := 0
+	for key, items := range itemsByKey {
+		sort.Sort(sortutil.Int32Slice(items))
+		items = items[:sortutil.Dedupe(sortutil.Int32Slice(items))]
 
-		if changedKeyCount == 0 {
-			log.Debug("No updates were merged, state is up-to-date.")
+		sa.WithWriteLock(func() {
+			if sa.store.RemoveItems(key, items) {
+				changedKeyCount++
+			}
+		})
+
+		if sa.forward != nil {
+			sa.forward.RemoveKey(key, items)
+		}
+	}
+
+	metricsUpdaterTermsRemoved.Inc(int64(termCount))
+	return changedKeyCount
+}
+
+func containsKey(keys []uint32, key uint32) bool {
+	idx := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+	return idx < len(keys) && keys[idx] == key
+}
+
+// Removes the given item ids from all keys of the store. The items are
+// passed using their positive ids. Returns the number of changed keys.
+func (sa *storeActions) RemoveItems(itemIds []int) (changedKeyCount int) {
+	items := make([]int32, len(itemIds))
+	for idx, itemId := range itemIds {
+		items[idx] = int32(-itemId)
+	}
+
+	sort.Sort(sortutil.Int32Slice(items))
+	items = items[:sortutil.Dedupe(sortutil.Int32Slice(items))]
+
+	withLock(&sa.updateLock, func() {
+		changedKeyCount = sa.removeItems(items)
+	})
+
+	return
+}
+
+// Removes all items from the keys of the given terms.
+func (sa *storeActions) RemoveTerms(terms []string) {
+	withLock(&sa.updateLock, func() {
+		for _, term := range terms {
+			key, ok := sa.dict.Lookup(term)
+			if !ok {
+				continue
+			}
+
+			if sa.forward != nil {
+				items := store.IteratorToList(nil, sa.store.GetIterator(key))
+				sa.forward.RemoveKey(key, items)
+			}
+
+			sa.WithWriteLock(func() {
+				sa.store.Replace(key, []int32{})
+			})
+		}
+	})
+}
+
+// Removes the sorted items from all keys. Must be called while holding the update lock.
+func (sa *storeActions) removeItems(items []int32) int {
+	if sa.forward != nil {
+		sa.forward.RemoveItems(items)
+	}
+
+	changedKeyCount := 0
+	for _, key := range sa.store.Keys() {
+		// only writers hold the update lock, so it is safe to read without the read lock.
+		if !store.ContainsAnyItem(sa.store.GetIterator(key), items) {
+			continue
 		}
 
-		metricsUpdaterKeysChanged.Inc(changedKeyCount)
 		sa.WithWriteLock(func() {
-			sa.storeState = newState
+			if sa.store.RemoveItems(key, items) {
+				changedKeyCount++
+			}
+		})
+	}
+
+	return changedKeyCount
+}
+
+type reencodeResult struct {
+	KeysChanged  int
+	MemoryBefore string
+	MemoryAfter  string
+	MemorySaved  string
+}
 
-			log.WithField("duration", time.Since(start)).
-				WithField("keyCount", changedKeyCount).
-				WithField("state", sa.storeState).
-				WithField("memory", sa.store.MemorySize()).
-				Info("Update finished and merged")
+// Encodes every key again using the current codec policy.
+func (sa *storeActions) Reencode(j *job) {
+	withLock(&sa.updateLock, func() {
+		start := time.Now()
+
+		var keys []uint32
+		var memoryBefore store.ByteSize
+		sa.WithReadLock(func() {
+			keys = sa.store.Keys()
+			memoryBefore = sa.store.MemorySize()
 		})
-	})
 
-	return more
+		j.SetTotal(len(keys))
+
+		changedKeyCount := 0
+		for _, key := range keys {
+			sa.WithWriteLock(func() {
+				if store.Reencode(sa.store, key) {
+					changedKeyCount++
+				}
+			})
+
+			j.Increment()
+		}
+
+		var memoryAfter store.ByteSize
+		sa.WithReadLock(func() {
+			memoryAfter = sa.store.MemorySize()
+		})
+
+		j.SetResult(reencodeResult{
+			KeysChanged:  changedKeyCount,
+			MemoryBefore: memoryBefore.String(),
+			MemoryAfter:  memoryAfter.String(),
+			MemorySaved:  (memoryBefore - memoryAfter).String(),
+		})
+
+		log.WithField("duration", time.Since(start)).
+			WithField("keyCount", changedKeyCount).
+			WithField("memorySaved", memoryBefore-memoryAfter).
+			Info("Re-encoding of the store finished")
+	})
 }
 
 func (sa *storeActions) WriteCheckpoint(file string) (err error) {
 	sa.WithReadLock(func() {
 		start := time.Now()
-		err = store.WriteCheckpointFile(file, sa.storeState, sa.store)
+		state := sa.storeState
+		state.IngestedEventIds = sa.ingested.Ids()
+
+		err = store.WriteCheckpointFile(file, state, sa.store, sa.dict, sa.forward)
 		if err != nil {
 			log.Warn("Could not write checkpoint file:", err)
 			metricsCheckpointError.Inc(1)
@@ -129,58 +455,148 @@ func (sa *storeActions) WriteCheckpoint(file string) (err error) {
 
 func (sa *storeActions) Search(query string, olderThan int32, shuffle bool) (result []int32, err error) {
 	err = withRecovery("search", func() {
-		queryLowerCase := strings.ToLower(query)
+		config := sa.Config()
 
 		// parse the query into an ast
-		pr := parser.NewParser(strings.NewReader(queryLowerCase))
-		ast, err := pr.Parse()
-		if err != nil {
-			panic(err)
-		}
+		parsed := parseQuery(query)
 
-		if sa.UseOptimizer {
+		ast := parsed
+		if config.UseOptimizer {
 			// optimize the ast for maximum performance!!!1
-			ast = parser.Optimize(ast)
+			ast = parser.Optimize(parsed)
 		}
 
 		metricsSearch.Time(func() {
 			sa.WithReadLock(func() {
 				log.WithField("query", query).WithField("older", olderThan).Debug("Start search query")
-				iter := parser.ToIterator(ast, func(str string) store.ItemIterator {
-					var hash uint32
-					if str != "__all" {
-						if len(str) < 2 || str[1] != ':' {
-							str = CleanString(str)
-						}
-
-						hash = HashWord(str)
-					}
-
-					return sa.store.GetIterator(hash)
-				})
-
-				switch {
-				case shuffle:
-					iter = store.NewShuffledIterator(iter)
-
-				case olderThan > 0:
-					// skipping posts. we need to invert the item id here, cause
-					// the search is running on negative ids internally
-					store.IteratorSkipUntil(iter, -olderThan)
-
-					// we only skipped to the item that is equal to olderThan, so we need to
-					// skip the next element.
-					if iter.HasMore() {
-						iter.Next()
-					}
-				}
-
-				// get the first 120 results
-				iter = store.NewLimitIterator(120, store.NewNegateIterator(iter))
-				result = store.IteratorToList(nil, iter)
+				result = sa.executeQuery(ast, olderThan, shuffle)
 			})
 		})
+
+		// shuffled results can not be compared, so we only sample the other queries.
+		if !shuffle && sa.shadow != nil && rand.Float64() < config.ShadowSampleRate {
+			sa.shadow.Submit(shadowQuery{
+				Query:     query,
+				OlderThan: olderThan,
+				Parsed:    parsed,
+				Actual:    ast,
+				Rules:     config.ShadowRules,
+			})
+		}
 	})
 
 	return
 }
+
+func parseQuery(query string) *parser.Node {
+	pr := parser.NewParser(strings.NewReader(strings.ToLower(query)))
+	ast, err := pr.Parse()
+	if err != nil {
+		panic(err)
+	}
+
+	return ast
+}
+
+// Executes the query. The caller must hold the read lock.
+func (sa *storeActions) executeQuery(ast *parser.Node, olderThan int32, shuffle bool) []int32 {
+	iter := parser.ToIterator(ast, sa.termIterator)
+
+	switch {
+	case shuffle:
+		iter = store.NewShuffledIterator(iter)
+
+	case olderThan > 0:
+		// skipping posts. we need to invert the item id here, cause
+		// the search is running on negative ids internally
+		store.IteratorSkipUntil(iter, -olderThan)
+
+		// we only skipped to the item that is equal to olderThan, so we need to
+		// skip the next element.
+		if iter.HasMore() {
+			iter.Next()
+		}
+	}
+
+	// get the first 120 results
+	iter = store.NewLimitIterator(120, store.NewNegateIterator(iter))
+	return store.IteratorToList(nil, iter)
+}
+
+// Estimates the number of items the query would return without a limit.
+func (sa *storeActions) EstimateResultSize(ast *parser.Node) (size int) {
+	sa.WithReadLock(func() {
+		var universe int
+		if key, ok := sa.dict.Lookup(store.AllTerm); ok {
+			universe = sa.store.Cardinality(key)
+		}
+
+		size = store.EstimateSize(parser.ToIterator(ast, sa.termIterator), universe)
+	})
+
+	return
+}
+
+// Returns the readable terms of an item using the forward index. Returns
+// false, if the forward index is disabled.
+func (sa *storeActions) ItemTags(itemId int) (tagsapi.ItemTags, bool) {
+	item := int32(-itemId)
+	result := tagsapi.ItemTags{Id: itemId, Terms: []string{}, Attributes: []string{}}
+
+	// a rebuild replaces the forward index together with the store.
+	var enabled bool
+	var keys []uint32
+	sa.WithReadLock(func() {
+		if sa.forward == nil {
+			return
+		}
+
+		enabled = true
+		for _, key := range sa.forward.Keys(item) {
+			// the forward index is not updated when a whole key is removed.
+			if store.ContainsAnyItem(sa.store.GetIterator(key), []int32{item}) {
+				keys = append(keys, key)
+			}
+		}
+	})
+
+	if !enabled {
+		return result, false
+	}
+
+	for _, key := range keys {
+		term, ok := sa.dict.Term(key)
+		switch {
+		case !ok:
+			result.UnknownKeys = append(result.UnknownKeys, key)
+
+		case term == store.AllTerm:
+			continue
+
+		case hasTermPrefix(term):
+			result.Attributes = append(result.Attributes, term)
+
+		default:
+			result.Terms = append(result.Terms, term)
+		}
+	}
+
+	sort.Strings(result.Terms)
+	sort.Strings(result.Attributes)
+	return result, true
+}
+
+func (sa *storeActions) termIterator(str string) store.ItemIterator {
+	if str != store.AllTerm {
+		if !hasTermPrefix(str) {
+			str = CleanString(str)
+		}
+	}
+
+	key, ok := sa.dict.Lookup(str)
+	if !ok {
+		return store.NewEmptyIterator()
+	}
+
+	return sa.store.GetIterator(key)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/cheggaaa/pb.v1"
+)
+
+// Streams all rows of a table to the consumer.
+type itemStream func(consumer func(postInfo)) error
+type tagStream func(consumer func(tagInfo)) error
+
+// Builds a new store from all items and tags. Items and tags are streamed and
+// pushed into two builders in parallel. The returned state contains the
+// cursors behind the last item and tag.
+func bootstrap(dict *store.Dictionary, items itemStream, tags tagStream) (store.IterStore, store.StoreState, error) {
+	var state store.StoreState
+
+	var itemStore, tagStore store.IterStore
+	var itemErr, tagErr error
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		builder := store.NewStoreBuilder(dict.KeyOf)
+
+		cursor := itemCursor{}
+		itemErr = items(func(postInfo postInfo) {
+			for _, term := range derivedTerms(postInfo) {
+				builder.Push(term, int32(-postInfo.Id))
+			}
+
+			// rows are not ordered, keep the largest cursor.
+			if cursor.Before(postInfo) {
+				cursor = itemCursor{Updated: postInfo.Updated, Id: postInfo.Id}
+			}
+		})
+
+		state.LastItemUpdateTime, state.LastItemId = cursor.Updated, cursor.Id
+		itemStore = builder.Build()
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		builder := store.NewStoreBuilder(dict.KeyOf)
+
+		tagErr = tags(func(info tagInfo) {
+			for _, term := range indexedTagTerms(info) {
+				builder.Push(term, int32(-info.ItemId))
+			}
+
+			if info.Id > state.LastTagId {
+				state.LastTagId = info.Id
+			}
+		})
+
+		tagStore = builder.Build()
+	}()
+
+	wg.Wait()
+
+	if itemErr != nil {
+		return nil, state, itemErr
+	}
+
+	if tagErr != nil {
+		return nil, state, tagErr
+	}
+
+	// the stores only share a few keys like "__all", merge them.
+	store.MergeIterStores(itemStore, tagStore)
+	return itemStore, state, nil
+}
+
+// Bootstraps a store from the postgres database and writes it as a checkpoint.
+func bootstrapFromPostgres(db *sqlx.DB, dict *store.Dictionary, checkpointFile string) error {
+	// one connection for items and one for tags.
+	db.SetMaxOpenConns(2)
+
+	// rows deleted or changed while bootstrapping are processed again by the updater.
+	state := store.StoreState{
+		DerivationRulesHash:    derivationRules.Hash,
+		TagConfidenceThreshold: tagConfidenceThreshold,
+		IndexLowConfidenceTags: indexLowConfidenceTags,
+	}
+	for table, cursor := range map[string]*int{
+		"tags_deleted":    &state.LastDeletedTagId,
+		"items_deleted":   &state.LastDeletedItemId,
+		"tags_confidence": &state.LastTagConfidenceId,
+	} {
+		var err error
+		if *cursor, err = maxTableId(db, table); err != nil {
+			return err
+		}
+	}
+
+	itemBar := progressBar(db, "items")
+	tagBar := progressBar(db, "tags")
+
+	pool, err := pb.StartPool(itemBar, tagBar)
+	if err != nil {
+		return err
+	}
+
+	items := func(consumer func(postInfo)) error {
+		return streamRows(db, selectItems, func(rows *sqlx.Rows) error {
+			var postInfo postInfo
+			if err := rows.StructScan(&postInfo); err != nil {
+				return err
+			}
+
+			consumer(postInfo)
+			itemBar.Increment()
+			return nil
+		})
+	}
+
+	tags := func(consumer func(tagInfo)) error {
+		return streamRows(db, "SELECT id, item_id, lower(tag) as tag, confidence FROM tags", func(rows *sqlx.Rows) error {
+			var info tagInfo
+			if err := rows.StructScan(&info); err != nil {
+				return err
+			}
+
+			consumer(info)
+			tagBar.Increment()
+			return nil
+		})
+	}
+
+	iterStore, streamedState, err := bootstrap(dict, items, tags)
+	pool.Stop()
+
+	if err != nil {
+		return err
+	}
+
+	state.LastItemUpdateTime = streamedState.LastItemUpdateTime
+	state.LastItemId = streamedState.LastItemId
+	state.LastTagId = streamedState.LastTagId
+
+	log.WithField("state", state).
+		WithField("keyCount", iterStore.KeyCount()).
+		WithField("memory", iterStore.MemorySize()).
+		Info("Bootstrap finished, writing checkpoint")
+
+	return store.WriteCheckpointFile(checkpointFile, state, iterStore, dict, nil)
+}
+
+// Returns the largest id of the table. A table that does not exist yet is
+// treated as an empty table.
+func maxTableId(db *sqlx.DB, table string) (int, error) {
+	var id int
+	err := db.Get(&id, "SELECT COALESCE(MAX(id), 0) FROM "+table)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "undefined_table" {
+		log.WithField("table", table).Warn("Table does not exist, starting with cursor 0")
+		return 0, nil
+	}
+
+	return id, err
+}
+
+// Creates a progress bar with the estimated number of rows of the table.
+func progressBar(db *sqlx.DB, table string) *pb.ProgressBar {
+	var estimate int64
+	if err := db.Get(&estimate, "SELECT reltuples::bigint FROM pg_class WHERE relname = $1", table); err != nil {
+		log.WithError(err).Warnf("Could not estimate the size of %s", table)
+	}
+
+	bar := pb.New64(estimate).Prefix(table + " ")
+	bar.ShowFinalTime = true
+	return bar
+}
+
+// Executes the query and calls the consumer for each row while the rows are
+// received from the database.
+func streamRows(db *sqlx.DB, query string, consumer func(rows *sqlx.Rows) error) error {
+	rows, err := db.Queryx(query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := consumer(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+)
+
+func TestBootstrapMatchesUpdates(t *testing.T) {
+	updated := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	var items []postInfo
+	var tags []tagInfo
+	for id := 1; id <= 500; id++ {
+		postInfo := testPostInfo(id)
+		postInfo.Score = id * 7
+		postInfo.Flags = 1 << uint(id%4)
+		postInfo.Updated = updated.Add(time.Duration(id%50) * time.Second)
+		items = append(items, postInfo)
+
+		tags = append(tags,
+			tagInfo{Id: 2 * id, ItemId: id, Tag: "kadse"},
+			tagInfo{Id: 2*id + 1, ItemId: id % 17, Tag: "repost"})
+	}
+
+	itemStream := func(consumer func(postInfo)) error {
+		for _, postInfo := range items {
+			consumer(postInfo)
+		}
+
+		return nil
+	}
+
+	tagStream := func(consumer func(tagInfo)) error {
+		for _, info := range tags {
+			consumer(info)
+		}
+
+		return nil
+	}
+
+	dict := store.NewDictionary(nil)
+	bootstrapped, state, err := bootstrap(dict, itemStream, tagStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := buildUpdates(dict, changeBatch{Items: items, Tags: tags}).Updates
+
+	if bootstrapped.KeyCount() != expected.KeyCount() {
+		t.Fatalf("Expected %d keys, got %d", expected.KeyCount(), bootstrapped.KeyCount())
+	}
+
+	for _, key := range expected.Keys() {
+		testStoreKey(t, expected, bootstrapped, key)
+	}
+
+	if state.LastTagId != 1001 || state.LastItemId != 499 || !state.LastItemUpdateTime.Equal(updated.Add(49*time.Second)) {
+		t.Errorf("Unexpected state %+v", state)
+	}
+}
+
+func testStoreKey(t *testing.T, expected, actual store.IterStore, key uint32) {
+	expectedValues := store.IteratorToList(nil, expected.GetIterator(key))
+	actualValues := store.IteratorToList(nil, actual.GetIterator(key))
+
+	if !equalInt32s(expectedValues, actualValues) {
+		t.Errorf("Values of key %d differ, expected %v, got %v", key, expectedValues, actualValues)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Rules that derive the terms of items and tags. Each rule is a text/template
+// that is executed with a postInfo (item) or a tagInfo (tag). The output is
+// split into whitespace separated terms, each term must start with the prefix
+// of the rule. Terms of the item rules are removed from an item when it is
+// updated and the rule does not produce them anymore. If the prefixes of an
+// item rule and a tag rule match a term, the longer prefix wins, so item and
+// tag rules must not use the same prefix. A rule that fails for a row is
+// skipped for that row.
+const defaultDerivationRules = `
+- name: user
+  prefix: "u:"
+  item: "u:{{clean .Username}}"
+
+- name: flags
+  prefix: "f:"
+  item: |
+    {{if flag .Flags 1}}f:sfw{{else if flag .Flags 2}}f:nsfw{{else if flag .Flags 4}}f:nsfl{{else if flag .Flags 8}}f:nsfp{{end}}
+    {{if .Promoted}}f:top{{end}}
+    {{if .HasText}}f:text{{end}}
+    {{if .HasAudio}}f:sound{{end}}
+    {{if .Controversial}}f:controversial{{end}}
+
+- name: mark
+  prefix: "m:"
+  item: "{{if eq .UserMark 6}}m:ftb{{else if eq .UserMark 1}}m:newfag{{end}}"
+
+- name: quality
+  prefix: "q:"
+  item: |
+    {{if gt .Width 3800}}q:2160p q:4k
+    {{else if gt .Width 1900}}q:1080p q:hd
+    {{else if gt .Width 1200}}q:720p q:hd
+    {{else if gt .Width 600}}q:sd
+    {{else}}q:kartoffel{{end}}
+
+- name: date
+  prefix: "d:"
+  item: '{{with date .CreatedEpoch}}d:{{.Format "2006"}} d:{{.Format "2006:01"}}{{end}}'
+
+# bins of 100, a post with score 1125 is in the bins 100, 200, ... and 1100
+- name: score
+  prefix: "s:"
+  item: "{{range bins .Score 100}}s:{{.}} {{end}}{{if lt .Score -300}}s:shit{{end}}"
+
+- name: repost
+  prefix: "f:repost"
+  tag: '{{if eq (lower .Tag) "repost"}}f:repost{{end}}'
+`
+
+// The rules used to derive terms, replaced at startup by the configured rules.
+var derivationRules = mustParseDerivationRules([]byte(defaultDerivationRules))
+
+var defaultDerivationRulesHash = derivationRules.Hash
+
+var derivationFuncs = template.FuncMap{
+	"clean": CleanString,
+	"lower": strings.ToLower,
+
+	"flag": func(flags, flag int) bool {
+		return flags&flag != 0
+	},
+
+	"date": func(epoch int) time.Time {
+		return time.Unix(int64(epoch), 0)
+	},
+
+	"bins": func(value, size int) []int {
+		var bins []int
+		for bin := 1; bin <= value/size; bin++ {
+			bins = append(bins, bin*size)
+		}
+
+		return bins
+	},
+}
+
+type derivationRule struct {
+	Name   string `yaml:"name"`
+	Prefix string `yaml:"prefix"`
+	Item   string `yaml:"item"`
+	Tag    string `yaml:"tag"`
+
+	template *template.Template
+}
+
+// Executes the rule and returns the derived terms.
+func (rule *derivationRule) terms(data interface{}) ([]string, error) {
+	var buffer bytes.Buffer
+	if err := rule.template.Execute(&buffer, data); err != nil {
+		return nil, err
+	}
+
+	terms := strings.Fields(buffer.String())
+	for _, term := range terms {
+		if !strings.HasPrefix(term, rule.Prefix) {
+			return nil, fmt.Errorf("Rule '%s' derived term '%s' without prefix '%s'", rule.Name, term, rule.Prefix)
+		}
+	}
+
+	return terms, nil
+}
+
+type derivationRuleSet struct {
+	itemRules []*derivationRule
+	tagRules  []*derivationRule
+
+	// Hash of the rule file, stored in the state to detect changes.
+	Hash string
+}
+
+func parseDerivationRules(content []byte) (*derivationRuleSet, error) {
+	var rules []*derivationRule
+	if err := yaml.UnmarshalStrict(content, &rules); err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum(content)
+	ruleSet := &derivationRuleSet{Hash: hex.EncodeToString(hash[:])}
+
+	names := make(map[string]bool)
+
+	// the longest prefix decides if a term is item derived, equal prefixes are ambiguous.
+	itemPrefixes := make(map[string]bool)
+	tagPrefixes := make(map[string]bool)
+
+	for _, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("Rule name '%s' is empty or not unique", rule.Name)
+		}
+
+		names[rule.Name] = true
+
+		// only terms with a prefix like "f:" are searched without cleaning.
+		if len(rule.Prefix) < 2 || rule.Prefix[1] != ':' {
+			return nil, fmt.Errorf("Rule '%s' needs a prefix like 'x:', got '%s'", rule.Name, rule.Prefix)
+		}
+
+		// validate the rule by executing it with an empty item or tag.
+		var text string
+		var sample interface{}
+		switch {
+		case rule.Item != "" && rule.Tag == "":
+			if tagPrefixes[rule.Prefix] {
+				return nil, fmt.Errorf("Rule '%s' uses the prefix '%s' of a tag rule", rule.Name, rule.Prefix)
+			}
+
+			text, sample = rule.Item, postInfo{}
+			itemPrefixes[rule.Prefix] = true
+			ruleSet.itemRules = append(ruleSet.itemRules, rule)
+
+		case rule.Tag != "" && rule.Item == "":
+			if itemPrefixes[rule.Prefix] {
+				return nil, fmt.Errorf("Rule '%s' uses the prefix '%s' of an item rule", rule.Name, rule.Prefix)
+			}
+
+			text, sample = rule.Tag, tagInfo{}
+			tagPrefixes[rule.Prefix] = true
+			ruleSet.tagRules = append(ruleSet.tagRules, rule)
+
+		default:
+			return nil, fmt.Errorf("Rule '%s' needs either an item or a tag template", rule.Name)
+		}
+
+		var err error
+		if rule.template, err = template.New(rule.Name).Funcs(derivationFuncs).Parse(text); err != nil {
+			return nil, err
+		}
+
+		if _, err := rule.terms(sample); err != nil {
+			return nil, fmt.Errorf("Rule '%s' is invalid: %s", rule.Name, err)
+		}
+	}
+
+	return ruleSet, nil
+}
+
+func mustParseDerivationRules(content []byte) *derivationRuleSet {
+	ruleSet, err := parseDerivationRules(content)
+	if err != nil {
+		panic(err)
+	}
+
+	return ruleSet
+}
+
+func readDerivationRules(file string) (*derivationRuleSet, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDerivationRules(content)
+}
+
+// Executes the rules and returns all derived terms. A rule that fails for
+// the data is skipped, the other rules still derive their terms.
+func executeRules(rules []*derivationRule, data interface{}) []string {
+	var terms []string
+	for _, rule := range rules {
+		ruleTerms, err := rule.terms(data)
+		if err != nil {
+			log.WithError(err).WithField("rule", rule.Name).Warn("Skipping derivation rule")
+			metricsUpdaterRuleError.Inc(1)
+			continue
+		}
+
+		terms = append(terms, ruleTerms...)
+	}
+
+	return terms
+}
+
+// Returns the terms derived from the item row.
+func (ruleSet *derivationRuleSet) ItemTerms(postInfo postInfo) []string {
+	return executeRules(ruleSet.itemRules, postInfo)
+}
+
+// Returns the terms derived from a tag, without the words of the tag.
+func (ruleSet *derivationRuleSet) TagTerms(info tagInfo) []string {
+	return executeRules(ruleSet.tagRules, info)
+}
+
+// Returns true, if the term is derived by an item rule. The rule with the
+// longest matching prefix decides.
+func (ruleSet *derivationRuleSet) IsItemDerived(term string) bool {
+	itemDerived := false
+	longestPrefix := 0
+
+	match := func(rules []*derivationRule, item bool) {
+		for _, rule := range rules {
+			if len(rule.Prefix) > longestPrefix && strings.HasPrefix(term, rule.Prefix) {
+				itemDerived, longestPrefix = item, len(rule.Prefix)
+			}
+		}
+	}
+
+	match(ruleSet.itemRules, true)
+	match(ruleSet.tagRules, false)
+	return itemDerived
+}
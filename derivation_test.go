@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDerivationRulesFromYaml(t *testing.T) {
+	ruleSet, err := parseDerivationRules([]byte(`
+- name: score
+  prefix: "s:"
+  item: "{{if gt .Score 1000}}s:great{{end}}"
+
+- name: oc
+  prefix: "f:oc"
+  tag: '{{if eq .Tag "oc"}}f:oc{{end}}'
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postInfo := testPostInfo(1)
+	postInfo.Score = 1500
+
+	terms := ruleSet.ItemTerms(postInfo)
+	if len(terms) != 1 || terms[0] != "s:great" {
+		t.Errorf("Unexpected item terms %v", terms)
+	}
+
+	terms = ruleSet.TagTerms(tagInfo{Tag: "oc"})
+	if len(terms) != 1 || terms[0] != "f:oc" {
+		t.Errorf("Unexpected tag terms %v", terms)
+	}
+
+	if ruleSet.Hash == derivationRules.Hash {
+		t.Error("Expected a different hash for different rules")
+	}
+}
+
+func TestDerivationRulesLongestPrefixDecides(t *testing.T) {
+	if !isItemDerived("f:sfw") || !isItemDerived("u:cha0s") {
+		t.Error("Expected terms of item rules to be item derived")
+	}
+
+	if isItemDerived("f:repost") || isItemDerived("kadse") {
+		t.Error("Expected terms of tag rules and tag words not to be item derived")
+	}
+}
+
+func TestDerivationRulesAreValidated(t *testing.T) {
+	invalid := map[string]string{
+		"unknown field":  `[{name: a, prefix: "a:", item: "{{.Unknown}}"}]`,
+		"unknown key":    `[{name: a, prefix: "a:", item: "a:b", typo: 1}]`,
+		"missing prefix": `[{name: a, item: "a:b"}]`,
+		"wrong prefix":   `[{name: a, prefix: "a:", item: "b:c"}]`,
+		"both templates": `[{name: a, prefix: "a:", item: "a:b", tag: "a:c"}]`,
+		"duplicate name": `[{name: a, prefix: "a:", item: "a:b"}, {name: a, prefix: "b:", item: "b:c"}]`,
+		"syntax error":   `[{name: a, prefix: "a:", item: "{{if}}"}]`,
+		"shared prefix":  `[{name: a, prefix: "a:", item: "a:b"}, {name: b, prefix: "a:", tag: "a:c"}]`,
+	}
+
+	for name, content := range invalid {
+		if _, err := parseDerivationRules([]byte(content)); err == nil {
+			t.Errorf("Expected an error for rules with %s", name)
+		}
+	}
+}
+
+func TestDefaultDerivationRulesDeriveQualities(t *testing.T) {
+	widths := map[int]string{
+		4000: "q:2160p q:4k",
+		2000: "q:1080p q:hd",
+		1280: "q:720p q:hd",
+		800:  "q:sd",
+		600:  "q:kartoffel",
+	}
+
+	for width, expected := range widths {
+		postInfo := testPostInfo(1)
+		postInfo.Width = width
+
+		var qualities []string
+		for _, term := range derivedTerms(postInfo) {
+			if strings.HasPrefix(term, "q:") {
+				qualities = append(qualities, term)
+			}
+		}
+
+		if strings.Join(qualities, " ") != expected {
+			t.Errorf("Expected %s for width %d, got %v", expected, width, qualities)
+		}
+	}
+}
+
+func TestDerivationRulesSkipFailingRule(t *testing.T) {
+	ruleSet, err := parseDerivationRules([]byte(`
+- name: broken
+  prefix: "a:"
+  item: "{{if gt .Score 0}}b:wrong{{end}}"
+
+- name: user
+  prefix: "u:"
+  item: "u:{{clean .Username}}"
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errors := metricsUpdaterRuleError.Count()
+
+	terms := ruleSet.ItemTerms(testPostInfo(1))
+	if len(terms) != 1 || terms[0] != "u:cha0s" {
+		t.Errorf("Expected only the terms of the working rule, got %v", terms)
+	}
+
+	if metricsUpdaterRuleError.Count() != errors+1 {
+		t.Error("Expected the failed rule to be counted")
+	}
+}
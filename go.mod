@@ -1,5 +1,7 @@
 module github.com/mopsalarm/go-pr0gramm-tags
 
+go 1.27.1
+
 require (
 	github.com/cznic/mathutil v0.0.0-20170901165910-91af0ce59d17
 	github.com/cznic/sortutil v0.0.0-20150617083342-4c7342852e65
@@ -17,7 +19,7 @@ require (
 	github.com/rcrowley/go-metrics v0.0.0-20161128210544-1f30fe9094a5
 	github.com/robfig/cron v0.0.0-20140119015047-b024fc5ea0e3
 	github.com/sirupsen/logrus v0.0.0-20170815202055-f006c2ac4710
-	github.com/ugorji/go v0.0.0-20170826155943-8c0409fcbb70
+	github.com/ugorji/go v0.0.0-20180813092308-00b869d2f4a5
 	golang.org/x/crypto v0.0.0-20170909204757-9ba3862cf6a5
 	golang.org/x/sys v0.0.0-20170909063139-a5054c7c1385
 	gopkg.in/cheggaaa/pb.v1 v1.0.0-20170824104120-657164d0228d
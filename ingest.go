@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Number of event ids to remember for detecting duplicated events.
+const ingestedEventIdCapacity = 100000
+
+// Remembers the ids of the most recently ingested events.
+type eventIdSet struct {
+	lock  sync.Mutex
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+// Returns true, if the id is in the set.
+func (set *eventIdSet) Contains(id string) (contains bool) {
+	withLock(&set.lock, func() {
+		contains = set.ids[id]
+	})
+
+	return
+}
+
+// Returns the ids in the set, the oldest id first.
+func (set *eventIdSet) Ids() []string {
+	var ids []string
+	withLock(&set.lock, func() {
+		for idx := range set.order {
+			id := set.order[(set.next+idx)%len(set.order)]
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+	})
+
+	return ids
+}
+
+// Adds the id to the set. Returns false, if the id was already in the set.
+func (set *eventIdSet) Add(id string) (added bool) {
+	withLock(&set.lock, func() {
+		if set.ids == nil {
+			set.ids = make(map[string]bool)
+			set.order = make([]string, ingestedEventIdCapacity)
+		}
+
+		if set.ids[id] {
+			return
+		}
+
+		// forget the oldest id
+		delete(set.ids, set.order[set.next])
+
+		set.ids[id] = true
+		set.order[set.next] = id
+		set.next = (set.next + 1) % len(set.order)
+
+		added = true
+	})
+
+	return
+}
+
+type ingestResult struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+}
+
+// Applies a batch of events. Events use the records of the NDJSON update
+// source with an additional "eventId" field. Events with an id that was
+// already ingested are skipped. If one of the events is invalid, no event
+// of the batch is applied. The ids are recorded only after the events
+// were applied and are stored with the checkpoint.
+func (sa *storeActions) Ingest(events []json.RawMessage) (result ingestResult, err error) {
+	eventIds := make([]string, len(events))
+
+	var validation changeBatch
+	for idx, event := range events {
+		var header struct {
+			EventId string `json:"eventId"`
+		}
+
+		if err := json.Unmarshal(event, &header); err != nil {
+			return result, fmt.Errorf("Event %d: %s", idx, err)
+		}
+
+		if header.EventId == "" {
+			return result, fmt.Errorf("Event %d: eventId is missing", idx)
+		}
+
+		if err := validation.addRecord(event); err != nil {
+			return result, fmt.Errorf("Event %d: %s", idx, err)
+		}
+
+		eventIds[idx] = header.EventId
+	}
+
+	// holding the update lock, no other batch can apply the same events.
+	withLock(&sa.updateLock, func() {
+		var changes changeBatch
+		var accepted []string
+
+		seen := make(map[string]bool)
+		for idx, event := range events {
+			eventId := eventIds[idx]
+			if seen[eventId] || sa.ingested.Contains(eventId) {
+				result.Duplicates++
+				continue
+			}
+
+			seen[eventId] = true
+			accepted = append(accepted, eventId)
+
+			changes.addRecord(event)
+		}
+
+		if len(accepted) == 0 {
+			return
+		}
+
+		err = withRecovery("ingest", func() {
+			// keep the state, the cursor of the update source is not changed by events.
+			batch := buildUpdates(sa.dict, changes)
+			batch.State = nil
+
+			sa.mergeUpdates(batch)
+		})
+
+		if err != nil {
+			return
+		}
+
+		// a checkpoint holds the read lock, it sees the events together with their ids.
+		sa.WithWriteLock(func() {
+			for _, eventId := range accepted {
+				sa.ingested.Add(eventId)
+			}
+		})
+
+		result.Accepted = len(accepted)
+	})
+
+	metricsIngestAccepted.Inc(int64(result.Accepted))
+	metricsIngestDuplicates.Inc(int64(result.Duplicates))
+	return
+}
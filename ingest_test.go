@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+)
+
+func events(records ...string) []json.RawMessage {
+	result := make([]json.RawMessage, len(records))
+	for idx, record := range records {
+		result[idx] = json.RawMessage(record)
+	}
+
+	return result
+}
+
+func TestIngestSkipsDuplicateEvents(t *testing.T) {
+	sa := newTestActions()
+	sa.storeState.LastTagId = 17
+
+	result, err := sa.Ingest(events(
+		`{"eventId": "a", "type": "item", "id": 1, "username": "cha0s", "flags": 1}`,
+		`{"eventId": "b", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`))
+
+	if err != nil || result.Accepted != 2 || result.Duplicates != 0 {
+		t.Fatalf("Expected two accepted events, got %+v, %v", result, err)
+	}
+
+	if !hasItem(sa, "kadse", 1) || !hasItem(sa, "f:sfw", 1) {
+		t.Error("Ingested events are missing in the store")
+	}
+
+	// a newer update of the item, followed by a redelivery of the old event
+	sa.Ingest(events(`{"eventId": "c", "type": "item", "id": 1, "username": "cha0s", "flags": 2}`))
+	result, _ = sa.Ingest(events(`{"eventId": "a", "type": "item", "id": 1, "username": "cha0s", "flags": 1}`))
+
+	if result.Accepted != 0 || result.Duplicates != 1 {
+		t.Errorf("Expected a duplicate event, got %+v", result)
+	}
+
+	if hasItem(sa, "f:sfw", 1) || !hasItem(sa, "f:nsfw", 1) {
+		t.Error("Duplicate event was applied again")
+	}
+
+	if sa.storeState.LastTagId != 17 {
+		t.Error("Ingestion must not change the state of the update source")
+	}
+}
+
+func TestIngestRejectsInvalidBatch(t *testing.T) {
+	sa := newTestActions()
+
+	for _, batch := range [][]json.RawMessage{
+		events(`{"eventId": "a", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`, `{"type": "tag", "id": 2, "itemId": 2, "tag": "kefer"}`),
+		events(`{"eventId": "a", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`, `{"eventId": "b", "type": "unknown"}`),
+	} {
+		if _, err := sa.Ingest(batch); err == nil {
+			t.Error("Expected an error for an invalid batch")
+		}
+	}
+
+	if hasItem(sa, "kadse", 1) {
+		t.Error("Events of an invalid batch were applied")
+	}
+
+	// the valid event was not marked as ingested
+	if result, _ := sa.Ingest(events(`{"eventId": "a", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`)); result.Accepted != 1 {
+		t.Error("Event of a rejected batch was marked as ingested")
+	}
+}
+
+func TestEventIdSetForgetsOldIds(t *testing.T) {
+	var set eventIdSet
+	for idx := 0; idx <= ingestedEventIdCapacity; idx++ {
+		set.Add(strconv.Itoa(idx))
+	}
+
+	if !set.Add("0") {
+		t.Error("The oldest id must be forgotten")
+	}
+
+	if set.Add(strconv.Itoa(ingestedEventIdCapacity)) {
+		t.Error("A recent id must be remembered")
+	}
+}
+
+func TestIngestSkipsDuplicatesWithinBatch(t *testing.T) {
+	sa := newTestActions()
+
+	result, err := sa.Ingest(events(
+		`{"eventId": "a", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`,
+		`{"eventId": "a", "type": "tag", "id": 2, "itemId": 2, "tag": "kefer"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Accepted != 1 || result.Duplicates != 1 || hasItem(sa, "kefer", 2) {
+		t.Errorf("Expected the second event to be a duplicate, got %+v", result)
+	}
+}
+
+func TestIngestedEventIdsAreStoredInCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	sa := newTestActions()
+	sa.Ingest(events(
+		`{"eventId": "a", "type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`,
+		`{"eventId": "b", "type": "tag", "id": 2, "itemId": 2, "tag": "kefer"}`))
+
+	file := filepath.Join(dir, "checkpoint")
+	if err := sa.WriteCheckpoint(file); err != nil {
+		t.Fatal(err)
+	}
+
+	var state store.StoreState
+	if err := store.ReadCheckpointFile(file, &state, store.NewIterStore(nil), store.NewDictionary(HashWord), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.IngestedEventIds) != 2 || state.IngestedEventIds[0] != "a" || state.IngestedEventIds[1] != "b" {
+		t.Errorf("Unexpected event ids %v in checkpoint", state.IngestedEventIds)
+	}
+
+	if len(sa.storeState.IngestedEventIds) != 0 {
+		t.Error("Event ids must not be kept in the running state")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type jobStatus struct {
+	Name     string
+	Running  bool
+	Started  time.Time
+	Finished time.Time   `json:",omitempty"`
+	Progress int         `json:",omitempty"`
+	Total    int         `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+	Result   interface{} `json:",omitempty"`
+}
+
+// A job that runs in the background. The job reports its progress
+// using the methods on this type.
+type job struct {
+	lock   sync.Mutex
+	status jobStatus
+}
+
+func (j *job) SetTotal(total int) {
+	withLock(&j.lock, func() {
+		j.status.Total = total
+	})
+}
+
+func (j *job) Increment() {
+	withLock(&j.lock, func() {
+		j.status.Progress++
+	})
+}
+
+func (j *job) SetResult(result interface{}) {
+	withLock(&j.lock, func() {
+		j.status.Result = result
+	})
+}
+
+func (j *job) Status() (status jobStatus) {
+	withLock(&j.lock, func() {
+		status = j.status
+	})
+
+	return
+}
+
+// Runs named jobs in the background. Only one job with the same
+// name can run at the same time.
+type jobRunner struct {
+	lock sync.Mutex
+	jobs map[string]*job
+}
+
+func (jr *jobRunner) Start(name string, fn func(j *job)) (err error) {
+	withLock(&jr.lock, func() {
+		if previous := jr.jobs[name]; previous != nil && previous.Status().Running {
+			err = fmt.Errorf("Job '%s' is already running", name)
+			return
+		}
+
+		if jr.jobs == nil {
+			jr.jobs = make(map[string]*job)
+		}
+
+		j := &job{status: jobStatus{Name: name, Running: true, Started: time.Now()}}
+		jr.jobs[name] = j
+
+		go func() {
+			err := withRecovery(name, func() {
+				fn(j)
+			})
+
+			if err != nil {
+				log.WithError(err).WithField("job", name).Warn("Job failed")
+			}
+
+			withLock(&j.lock, func() {
+				j.status.Running = false
+				j.status.Finished = time.Now()
+				if err != nil {
+					j.status.Error = err.Error()
+				}
+			})
+		}()
+	})
+
+	return
+}
+
+func (jr *jobRunner) Status(name string) (status jobStatus, ok bool) {
+	withLock(&jr.lock, func() {
+		if j := jr.jobs[name]; j != nil {
+			status, ok = j.Status(), true
+		}
+	})
+
+	return
+}
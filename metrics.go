@@ -1,11 +1,38 @@
 package main
 
-import "github.com/rcrowley/go-metrics"
+import (
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+	"github.com/rcrowley/go-metrics"
+)
 
 var metricsWriteLock = metrics.GetOrRegisterTimer("tags.writelock", nil)
 var metricsReadLock = metrics.GetOrRegisterTimer("tags.readlock", nil)
 var metricsUpdaterKeysChanged = metrics.GetOrRegisterCounter("tags.updater.keys.changed", nil)
+var metricsUpdaterTermsRemoved = metrics.GetOrRegisterCounter("tags.updater.terms.removed", nil)
+var metricsUpdaterItemsPurged = metrics.GetOrRegisterCounter("tags.updater.items.purged", nil)
 var metricsUpdaterError = metrics.GetOrRegisterCounter("tags.updater.error", nil)
+var metricsUpdaterRuleError = metrics.GetOrRegisterCounter("tags.updater.rules.error", nil)
 var metricsKeysCount = metrics.GetOrRegisterGauge("tags.keys.count", nil)
 var metricsSearch = metrics.GetOrRegisterTimer("tags.search", nil)
 var metricsCheckpointError = metrics.GetOrRegisterCounter("tags.checkpoint.error", nil)
+var metricsNotifierDisconnected = metrics.GetOrRegisterCounter("tags.notifier.disconnected", nil)
+var metricsIngestAccepted = metrics.GetOrRegisterCounter("tags.ingest.accepted", nil)
+var metricsIngestDuplicates = metrics.GetOrRegisterCounter("tags.ingest.duplicates", nil)
+var metricsShadowExecuted = metrics.GetOrRegisterCounter("tags.shadow.executed", nil)
+var metricsShadowMismatch = metrics.GetOrRegisterCounter("tags.shadow.mismatch", nil)
+var metricsShadowDropped = metrics.GetOrRegisterCounter("tags.shadow.dropped", nil)
+var metricsShadowError = metrics.GetOrRegisterCounter("tags.shadow.error", nil)
+
+func registerDictionaryMetrics(dict *store.Dictionary) {
+	metrics.GetOrRegister("tags.dictionary.terms", metrics.NewFunctionalGauge(func() int64 {
+		return int64(dict.Len())
+	}))
+
+	metrics.GetOrRegister("tags.dictionary.collisions", metrics.NewFunctionalGauge(func() int64 {
+		return int64(len(dict.Collisions()))
+	}))
+
+	metrics.GetOrRegister("tags.dictionary.legacy", metrics.NewFunctionalGauge(func() int64 {
+		return int64(dict.LegacyKeyCount())
+	}))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Notifies about changes in the update source.
+type Notifier interface {
+	// Receives a value when new data is available in the update source.
+	Changed() <-chan struct{}
+
+	// Receives the connection state when it changes. While disconnected,
+	// notifications might get lost.
+	Connected() <-chan bool
+
+	Close() error
+}
+
+// Delivers the events of a notifier without ever blocking the sender. Changes
+// are combined into one pending signal. The connection state is kept apart from
+// the changes and only the latest state is pending, so it is never dropped.
+type notifierSignals struct {
+	changed   chan struct{}
+	connected chan bool
+}
+
+func newNotifierSignals() *notifierSignals {
+	return &notifierSignals{
+		changed:   make(chan struct{}, 1),
+		connected: make(chan bool, 1),
+	}
+}
+
+func (s *notifierSignals) Changed() <-chan struct{} {
+	return s.changed
+}
+
+func (s *notifierSignals) Connected() <-chan bool {
+	return s.connected
+}
+
+func (s *notifierSignals) NotifyChanged() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+		// there is already a pending change.
+	}
+}
+
+// Replaces a pending connection state that was not received yet. Must only
+// be called by one goroutine.
+func (s *notifierSignals) SetConnected(connected bool) {
+	select {
+	case <-s.connected:
+	default:
+	}
+
+	s.connected <- connected
+}
+
+// Receives notifications using postgres LISTEN. The database needs to send a
+// notification when new items or tags are committed, for example by a trigger:
+//
+//	CREATE FUNCTION notify_tags() RETURNS trigger AS $$
+//	BEGIN PERFORM pg_notify('tags_updates', ''); RETURN NULL; END;
+//	$$ LANGUAGE plpgsql;
+//
+//	CREATE TRIGGER tags_notify AFTER INSERT OR UPDATE OR DELETE ON tags
+//	  FOR EACH STATEMENT EXECUTE PROCEDURE notify_tags();
+type postgresNotifier struct {
+	*notifierSignals
+	listener *pq.Listener
+}
+
+func newPostgresNotifier(connectionString, channel string) (*postgresNotifier, error) {
+	signals := newNotifierSignals()
+
+	// the callback must not block the listener.
+	listener := pq.NewListener(connectionString, 1*time.Second, 1*time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			switch event {
+			case pq.ListenerEventDisconnected:
+				log.WithError(err).Warn("Lost connection to postgres listener")
+				signals.SetConnected(false)
+
+			case pq.ListenerEventReconnected:
+				log.Info("Postgres listener reconnected")
+				signals.SetConnected(true)
+			}
+		})
+
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for notification := range listener.Notify {
+			// a nil notification is sent after reconnecting, this is already
+			// handled by the event callback.
+			if notification == nil {
+				continue
+			}
+
+			signals.NotifyChanged()
+		}
+	}()
+
+	return &postgresNotifier{notifierSignals: signals, listener: listener}, nil
+}
+
+func (n *postgresNotifier) Close() error {
+	return n.listener.Close()
+}
+
+// Runs the update function when the notifier reports changes. Bursts of
+// notifications are combined into one update after the debounce delay. While
+// the notifier is disconnected, the update function is polled using the poll
+// interval. Returns when the stop channel is closed.
+func runNotifiedUpdates(notifier Notifier, update func(), debounce, pollInterval time.Duration, stop <-chan struct{}) {
+	changed := notifier.Changed()
+	connected := notifier.Connected()
+
+	poller := time.NewTicker(pollInterval)
+	defer poller.Stop()
+
+	// only poll while disconnected.
+	var poll <-chan time.Time
+
+	var debounced <-chan time.Time
+
+	for {
+		select {
+		case <-stop:
+			return
+
+		case _, ok := <-changed:
+			if !ok {
+				log.Warn("Notifier was closed, falling back to polling")
+				changed, connected = nil, nil
+				poll = poller.C
+				continue
+			}
+
+			if debounced == nil {
+				debounced = time.After(debounce)
+			}
+
+		case isConnected := <-connected:
+			if !isConnected {
+				metricsNotifierDisconnected.Inc(1)
+				poll = poller.C
+				continue
+			}
+
+			// notifications might have been lost while disconnected.
+			poll = nil
+			update()
+
+		case <-debounced:
+			debounced = nil
+			update()
+
+		case <-poll:
+			update()
+		}
+	}
+}
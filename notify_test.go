@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeNotifier struct {
+	*notifierSignals
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{newNotifierSignals()}
+}
+
+func (n *fakeNotifier) Close() error {
+	close(n.changed)
+	return nil
+}
+
+// Starts runNotifiedUpdates and returns a channel that receives a value for each update.
+func startNotifiedUpdates(notifier Notifier, pollInterval time.Duration) (<-chan bool, func()) {
+	updates := make(chan bool, 100)
+	stop := make(chan struct{})
+
+	go runNotifiedUpdates(notifier, func() { updates <- true }, 20*time.Millisecond, pollInterval, stop)
+	return updates, func() { close(stop) }
+}
+
+func expectUpdates(t *testing.T, updates <-chan bool, count int, within time.Duration) {
+	timeout := time.After(within)
+	for idx := 0; idx < count; idx++ {
+		select {
+		case <-updates:
+		case <-timeout:
+			t.Fatalf("Expected %d updates, got %d", count, idx)
+		}
+	}
+}
+
+func expectNoUpdate(t *testing.T, updates <-chan bool, within time.Duration) {
+	select {
+	case <-updates:
+		t.Fatal("Did not expect an update")
+	case <-time.After(within):
+	}
+}
+
+func TestNotifiedUpdatesDebounceBursts(t *testing.T) {
+	notifier := newFakeNotifier()
+	updates, stop := startNotifiedUpdates(notifier, time.Hour)
+	defer stop()
+
+	for idx := 0; idx < 10; idx++ {
+		notifier.NotifyChanged()
+	}
+
+	expectUpdates(t, updates, 1, time.Second)
+	expectNoUpdate(t, updates, 100*time.Millisecond)
+
+	notifier.NotifyChanged()
+	expectUpdates(t, updates, 1, time.Second)
+}
+
+func TestNotifiedUpdatesPollWhileDisconnected(t *testing.T) {
+	notifier := newFakeNotifier()
+	updates, stop := startNotifiedUpdates(notifier, 10*time.Millisecond)
+	defer stop()
+
+	expectNoUpdate(t, updates, 50*time.Millisecond)
+
+	notifier.SetConnected(false)
+	expectUpdates(t, updates, 3, time.Second)
+
+	// reconnecting updates once and stops polling
+	notifier.SetConnected(true)
+	expectUpdates(t, updates, 1, time.Second)
+
+	// drain a poll that might have happened before reconnecting
+	time.Sleep(20 * time.Millisecond)
+	for len(updates) > 0 {
+		<-updates
+	}
+
+	expectNoUpdate(t, updates, 50*time.Millisecond)
+}
+
+func TestNotifiedUpdatesPollAfterClose(t *testing.T) {
+	notifier := newFakeNotifier()
+	updates, stop := startNotifiedUpdates(notifier, 10*time.Millisecond)
+	defer stop()
+
+	notifier.Close()
+	expectUpdates(t, updates, 3, time.Second)
+}
+
+func TestNotifiedUpdatesPollAfterDisconnectWhileUpdating(t *testing.T) {
+	notifier := newFakeNotifier()
+
+	updates := make(chan bool, 100)
+	release := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// the first update blocks the loop until it is released.
+	blocked := true
+	go runNotifiedUpdates(notifier, func() {
+		if blocked {
+			blocked = false
+			<-release
+		}
+
+		updates <- true
+	}, time.Millisecond, 10*time.Millisecond, stop)
+
+	notifier.NotifyChanged()
+	time.Sleep(20 * time.Millisecond)
+
+	// none of these must block, even though the loop does not receive them.
+	for idx := 0; idx < 100; idx++ {
+		notifier.NotifyChanged()
+	}
+
+	notifier.SetConnected(false)
+	close(release)
+
+	// the pending change and at least two polls.
+	expectUpdates(t, updates, 4, time.Second)
+}
@@ -29,7 +29,7 @@ func ToIterator(node *Node, makeIter IteratorFactory) store.ItemIterator {
 		return ToIterator(NewOpNode(WITHOUT, AllQueryNode, node.Children[0]), makeIter)
 
 	default:
-		panic(fmt.Errorf("Can not create iterator for node of type " + node.Type.String()))
+		panic(fmt.Errorf("Can not create iterator for node of type %s", node.Type))
 	}
 }
 
@@ -1,24 +1,67 @@
 package parser
 
 import (
+	"fmt"
+
 	"github.com/sirupsen/logrus"
 )
 
+type optimizerRule struct {
+	name      string
+	transform func(ctx *optimizeContext) NodeTransformer
+}
+
+// All optimizer rules in the order in which they are applied.
+var optimizerRules = []optimizerRule{
+	{"remove-unnecessary-nodes", func(ctx *optimizeContext) NodeTransformer { return ctx.optRemoveUnnecessaryNodes }},
+	{"simplify-flags", func(ctx *optimizeContext) NodeTransformer { return ctx.optSimplifyFlags }},
+	{"not-using-without", func(ctx *optimizeContext) NodeTransformer { return ctx.optImplementNotUsingWithout }},
+	{"combine-hierarchy", func(ctx *optimizeContext) NodeTransformer { return ctx.optCombineHierarchy }},
+	{"remove-self-canceling-without", func(ctx *optimizeContext) NodeTransformer { return ctx.optRemoveSelfCancelingWithout }},
+	{"simplify-children", func(ctx *optimizeContext) NodeTransformer { return ctx.optSimplifyChildren }},
+	{"simplify-canceling-without", func(ctx *optimizeContext) NodeTransformer { return ctx.optSimplifyCancelingOperationAndWithout }},
+	{"move-without-out-of-and", func(ctx *optimizeContext) NodeTransformer { return ctx.optMoveWithoutOutOfAnd }},
+}
+
+// Returns the names of all known optimizer rules.
+func OptimizerRuleNames() []string {
+	names := make([]string, len(optimizerRules))
+	for idx, rule := range optimizerRules {
+		names[idx] = rule.name
+	}
+
+	return names
+}
+
+// Checks that every name in the given list references a known optimizer rule.
+func ValidateOptimizerRules(names []string) error {
+	for _, name := range names {
+		if !containsString(OptimizerRuleNames(), name) {
+			return fmt.Errorf("Unknown optimizer rule '%s'", name)
+		}
+	}
+
+	return nil
+}
+
 func Optimize(root *Node) *Node {
+	return OptimizeWithRules(root, OptimizerRuleNames())
+}
+
+// Optimizes the tree using only the given subset of rules.
+// Unknown rule names are ignored.
+func OptimizeWithRules(root *Node, rules []string) *Node {
 	root = root.Clone()
 	canonicalizeNodeSortOrder(root)
 
 	for pass := 0; pass < 16; pass++ {
 		ctx := optimizeContext{}
-		functions := []NodeTransformer{
-			ctx.optRemoveUnnecessaryNodes,
-			ctx.optSimplifyFlags,
-			ctx.optImplementNotUsingWithout,
-			ctx.optCombineHierarchy,
-			ctx.optRemoveSelfCancelingWithout,
-			ctx.optSimplifyChildren,
-			ctx.optSimplifyCancelingOperationAndWithout,
-			ctx.optMoveWithoutOutOfAnd,
+
+		var functions []NodeTransformer
+		for _, rule := range optimizerRules {
+			if containsString(rules, rule.name) {
+				functions = append(functions, rule.transform(&ctx))
+			}
 		}
 
 		changed := false
@@ -290,3 +333,13 @@ func containsOnly(query string) NodeMatcher {
 		return false
 	}
 }
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
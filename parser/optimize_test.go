@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestValidateOptimizerRules(t *testing.T) {
+	if err := ValidateOptimizerRules(nil); err != nil {
+		t.Errorf("Expected no error for an empty rule list, got %s", err)
+	}
+
+	if err := ValidateOptimizerRules(OptimizerRuleNames()); err != nil {
+		t.Errorf("Expected all known rules to be valid, got %s", err)
+	}
+
+	if err := ValidateOptimizerRules([]string{"simplify-flags", "unknown-rule"}); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+}
+
+func TestOptimizeWithoutRulesKeepsTree(t *testing.T) {
+	tree := NewOpNode(NOT, NewQueryNode("a"))
+
+	optimized := OptimizeWithRules(tree, nil)
+	if !optimized.EqualTo(tree) {
+		t.Errorf("Expected the tree to be unchanged, got %+v", optimized)
+	}
+
+	if optimized == tree {
+		t.Error("Expected a copy of the tree")
+	}
+}
+
+func TestOptimizeWithRulesAppliesOnlyNamedRules(t *testing.T) {
+	tree := NewOpNode(NOT, NewOpNode(NOT, NewQueryNode("a")))
+
+	// without remove-unnecessary-nodes, both NOT nodes are replaced.
+	optimized := OptimizeWithRules(tree, []string{"not-using-without"})
+	expected := NewOpNode(WITHOUT, AllQueryNode, NewOpNode(WITHOUT, AllQueryNode, NewQueryNode("a")))
+	if !optimized.EqualTo(expected) {
+		t.Errorf("Expected %+v, got %+v", expected, optimized)
+	}
+
+	optimized = OptimizeWithRules(tree, []string{"remove-unnecessary-nodes"})
+	if !optimized.EqualTo(NewQueryNode("a")) {
+		t.Errorf("Expected the double negation to be removed, got %+v", optimized)
+	}
+
+	// the original tree must not be modified.
+	if tree.Type != NOT || tree.Children[0].Type != NOT {
+		t.Error("Optimizer modified the input tree")
+	}
+}
+
+func TestOptimizeWithRulesSimplifiesFlags(t *testing.T) {
+	tree := NewOpNode(OR, NewQueryNode("f:sfw"), NewQueryNode("f:nsfw"), NewQueryNode("f:nsfp"))
+
+	optimized := OptimizeWithRules(tree, []string{"simplify-flags"})
+	if !optimized.EqualTo(NewOpNode(NOT, nodeNsfl)) {
+		t.Errorf("Expected the flags to be replaced by NOT f:nsfl, got %+v", optimized)
+	}
+
+	if !Optimize(tree).EqualTo(OptimizeWithRules(tree, OptimizerRuleNames())) {
+		t.Error("Expected Optimize to apply all rules")
+	}
+}
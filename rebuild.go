@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+	log "github.com/sirupsen/logrus"
+)
+
+type rebuildResult struct {
+	Batches      int
+	KeyCount     uint32
+	MemoryBefore string
+	MemoryAfter  string
+	State        store.StoreState
+}
+
+// Starts the rebuild job using the update source of the store. The rebuild
+// is refused while ingestion is enabled, as the ingested events are not
+// part of the update source and would be lost.
+func (sa *storeActions) StartRebuild() error {
+	if sa.source == nil {
+		return errors.New("Updates are disabled")
+	}
+
+	if sa.ingestEnabled {
+		return errors.New("Rebuild is not possible while ingestion is enabled, ingested events would be lost")
+	}
+
+	return sa.jobs.Start("rebuild", func(j *job) {
+		sa.Rebuild(j, sa.source)
+	})
+}
+
+// Returns the state to start a rebuild with. The cursors of items, tags and
+// files are reset to read everything again. Rows deleted or changed before the
+// rebuild are already reflected by the source, so the cursors of deleted rows
+// and confidence changes are kept.
+func rebuildState(current store.StoreState) store.StoreState {
+	return store.StoreState{
+		Version:           current.Version,
+		LastDeletedTagId:  current.LastDeletedTagId,
+		LastDeletedItemId: current.LastDeletedItemId,
+
+		LastTagConfidenceId: current.LastTagConfidenceId,
+
+		DerivationRulesHash: derivationRules.Hash,
+
+		TagConfidenceThreshold: tagConfidenceThreshold,
+		IndexLowConfidenceTags: indexLowConfidenceTags,
+	}
+}
+
+// Builds a new store from the update source in the background while the
+// current store keeps serving queries and receiving updates. When the new
+// store has caught up, the live updates are paused, the remaining changes
+// are fetched and the new store replaces the current one. Events that were
+// ingested but are not part of the update source are lost, see StartRebuild.
+func (sa *storeActions) Rebuild(j *job, source UpdateSource) {
+	start := time.Now()
+
+	var current store.StoreState
+	var memoryBefore store.ByteSize
+	sa.WithReadLock(func() {
+		current = sa.storeState
+		memoryBefore = sa.store.MemorySize()
+	})
+
+	// the dictionary is shared, terms keep their keys in the new store.
+	staging := &storeActions{
+		store:      store.NewIterStore(nil),
+		storeState: rebuildState(current),
+		dict:       sa.dict,
+	}
+
+	if sa.forward != nil {
+		staging.forward = store.NewForwardIndex()
+	}
+
+	batches := 0
+	catchUp := func() {
+		for {
+			// the updater skips failed fetches, but the rebuild must not swap in an incomplete store.
+			changes, err := source.FetchChanges(staging.storeState)
+			if err != nil {
+				panic(err)
+			}
+
+			staging.applyUpdates(buildUpdates(sa.dict, changes))
+			batches++
+			j.Increment()
+
+			if !changes.More {
+				return
+			}
+		}
+	}
+
+	catchUp()
+
+	log.WithField("duration", time.Since(start)).
+		WithField("batches", batches).
+		Info("Rebuilt store caught up, pausing updates to swap stores")
+
+	withLock(&sa.updateLock, func() {
+		// no other update can run now, fetch the changes since the last batch.
+		catchUp()
+
+		sa.WithWriteLock(func() {
+			sa.store = staging.store
+			sa.storeState = staging.storeState
+			sa.forward = staging.forward
+
+			// collected again from the dictionary by the next update.
+			sa.derivedKeys = nil
+
+			// the terms of the rebuilt store adopted their legacy keys, the
+			// remaining legacy keys are not in the store anymore.
+			sa.dict.ForgetLegacyKeys()
+		})
+	})
+
+	result := rebuildResult{
+		Batches:      batches,
+		KeyCount:     staging.store.KeyCount(),
+		MemoryBefore: memoryBefore.String(),
+		MemoryAfter:  staging.store.MemorySize().String(),
+		State:        staging.storeState,
+	}
+
+	j.SetResult(result)
+
+	log.WithField("duration", time.Since(start)).
+		WithField("result", result).
+		Info("Rebuild of the store finished")
+}
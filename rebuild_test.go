@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+)
+
+func TestRebuildReplacesStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "0001.json")
+	writeFile(t, file, ""+
+		`{"type": "item", "id": 1, "username": "cha0s", "flags": 1}`+"\n"+
+		`{"type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`+"\n"+
+		`{"type": "tag", "id": 2, "itemId": 2, "tag": "kefer"}`+"\n", os.O_TRUNC)
+
+	source := newNdjsonSource(dir)
+	source.maxRecords = 2
+
+	sa := newTestActions()
+	for sa.UpdateOnce(source) {
+	}
+
+	// a key that is not provided by the source anymore.
+	sa.store.Replace(sa.dict.KeyOf("stale"), []int32{-1})
+
+	// a legacy key that is not adopted by any term of the source.
+	sa.dict.SetLegacyKeys([]uint32{HashWord("unknown")})
+
+	// settings changed since the store was built.
+	indexLowConfidenceTags = true
+	defer func() { indexLowConfidenceTags = false }()
+
+	j := &job{}
+	sa.Rebuild(j, source)
+
+	if hasItem(sa, "stale", 1) {
+		t.Error("Stale key was not removed by the rebuild")
+	}
+
+	if !hasItem(sa, "u:cha0s", 1) || !hasItem(sa, "kadse", 1) || !hasItem(sa, "kefer", 2) {
+		t.Error("Expected all records of the source in the rebuilt store")
+	}
+
+	if sa.storeState.LastFile != file {
+		t.Errorf("Unexpected cursor %s after rebuild", sa.storeState.LastFile)
+	}
+
+	if sa.storeState.DerivationRulesHash != derivationRules.Hash {
+		t.Error("Expected the hash of the current derivation rules after rebuild")
+	}
+
+	if sa.dict.LegacyKeyCount() != 0 {
+		t.Error("Expected the remaining legacy keys to be forgotten after rebuild")
+	}
+
+	if !sa.storeState.IndexLowConfidenceTags {
+		t.Error("Expected the current confidence settings after rebuild")
+	}
+
+	// two batches to build the store and an empty one to catch up before the swap.
+	if j.Status().Progress != 3 {
+		t.Errorf("Expected three batches, got %d", j.Status().Progress)
+	}
+}
+
+type failingSource struct{}
+
+func (failingSource) FetchChanges(state store.StoreState) (changeBatch, error) {
+	return changeBatch{}, errors.New("database is gone")
+}
+
+func TestRebuildKeepsStoreOnError(t *testing.T) {
+	sa := newTestActions()
+	applyItems(sa, testPostInfo(1))
+
+	var jobs jobRunner
+	if err := jobs.Start("rebuild", func(j *job) { sa.Rebuild(j, failingSource{}) }); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		status, _ := jobs.Status("rebuild")
+		if !status.Running {
+			if status.Error == "" {
+				t.Error("Expected the rebuild job to fail")
+			}
+
+			break
+		}
+
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	if !hasItem(sa, store.AllTerm, 1) {
+		t.Error("Store was replaced after a failed rebuild")
+	}
+}
+
+func TestRebuildIsRefusedWhileIngestionIsEnabled(t *testing.T) {
+	sa := newTestActions()
+	sa.source = failingSource{}
+	sa.ingestEnabled = true
+
+	if err := sa.StartRebuild(); err == nil {
+		t.Error("Expected the rebuild to be refused")
+	}
+
+	if _, ok := sa.jobs.Status("rebuild"); ok {
+		t.Error("Rebuild job was started")
+	}
+}
+
+func TestItemTagsDuringRebuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeFile(t, filepath.Join(dir, "0001.json"), ""+
+		`{"type": "item", "id": 1, "username": "cha0s", "flags": 1}`+"\n"+
+		`{"type": "tag", "id": 1, "itemId": 1, "tag": "kadse"}`+"\n", os.O_TRUNC)
+
+	source := newNdjsonSource(dir)
+
+	sa := newTestActions()
+	sa.forward = store.NewForwardIndex()
+	for sa.UpdateOnce(source) {
+	}
+
+	// run with -race to detect unsynchronized access to the swapped index.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sa.Rebuild(&job{}, source)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		result, ok := sa.ItemTags(1)
+		if !ok || len(result.Terms) != 1 || result.Terms[0] != "kadse" {
+			t.Fatalf("Unexpected item tags %+v", result)
+		}
+	}
+
+	if _, ok := newTestActions().ItemTags(1); ok {
+		t.Error("Expected no item tags without a forward index")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"net/http"
 	"time"
 
@@ -13,7 +15,7 @@ import (
 	"strconv"
 )
 
-func restApi(httpListen string, actions *storeActions, checkpointFile string) {
+func restApi(httpListen string, actions *storeActions, checkpointFile string, ingestToken string) {
 	searchHandler := func(c *gin.Context) {
 		query := c.Param("query")
 		random := c.Query("random") == "true"
@@ -50,22 +52,96 @@ func restApi(httpListen string, actions *storeActions, checkpointFile string) {
 	r.GET("/query/", searchHandler)
 	r.GET("/query/:query", searchHandler)
 
+	r.GET("/item/:id/tags", func(c *gin.Context) {
+		itemId, err := strconv.Atoi(c.Param("id"))
+		if err != nil || itemId <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+			return
+		}
+
+		result, ok := actions.ItemTags(itemId)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "forward index is disabled"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	r.POST("/ingest", func(c *gin.Context) {
+		if ingestToken == "" {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "ingestion is disabled"})
+			return
+		}
+
+		authorization := []byte(c.Request.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(authorization, []byte("Bearer "+ingestToken)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+
+		var body struct {
+			Events []json.RawMessage `json:"events"`
+		}
+
+		if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := actions.Ingest(body.Events)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
 	r.POST("/admin/write-checkpoint", func(c *gin.Context) {
 		start := time.Now()
 		actions.WriteCheckpoint(checkpointFile)
 		c.JSON(http.StatusOK, gin.H{"duration": time.Since(start).String()})
 	})
 
-	r.POST("/admin/rebuild-items", func(c *gin.Context) {
-		actions.WithWriteLock(func() {
-			actions.storeState.LastItemUpdateTime = time.Unix(0, 0)
-		})
+	rebuildHandler := func(c *gin.Context) {
+		if actions.source == nil {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "updates are disabled"})
+			return
+		}
+
+		if err := actions.StartRebuild(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"status": "/admin/jobs/rebuild"})
+	}
+
+	// items and tags are always rebuilt together into a new store. The store
+	// is built from the update source only, so a rebuild is refused with a
+	// conflict while ingestion is enabled instead of dropping ingested events.
+	r.POST("/admin/rebuild-items", rebuildHandler)
+	r.POST("/admin/rebuild-tags", rebuildHandler)
+	r.POST("/admin/jobs/rebuild", rebuildHandler)
+
+	r.POST("/admin/jobs/reencode", func(c *gin.Context) {
+		if err := actions.jobs.Start("reencode", actions.Reencode); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"status": "/admin/jobs/reencode"})
 	})
 
-	r.POST("/admin/rebuild-tags", func(c *gin.Context) {
-		actions.WithWriteLock(func() {
-			actions.storeState.LastTagId = 0
-		})
+	r.GET("/admin/jobs/:name", func(c *gin.Context) {
+		status, ok := actions.jobs.Status(c.Param("name"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
 	})
 
 	r.GET("/admin/parse/:query", func(c *gin.Context) {
@@ -77,27 +153,83 @@ func restApi(httpListen string, actions *storeActions, checkpointFile string) {
 			return
 		}
 
+		optimized := parser.Optimize(tree)
 		c.JSON(http.StatusOK, gin.H{
-			"parsed":    tree,
-			"optimized": parser.Optimize(tree),
+			"parsed":        tree,
+			"optimized":     optimized,
+			"estimatedSize": actions.EstimateResultSize(optimized),
 		})
 	})
 
+	r.GET("/admin/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, actions.Config())
+	})
+
 	r.POST("/admin/config", func(c *gin.Context) {
-		if value := c.PostForm("optimize"); value != "" {
-			actions.UseOptimizer = value == "true"
+		var sampleRate *float64
+		if value := c.PostForm("shadow-sample-rate"); value != "" {
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "shadow-sample-rate must be between 0 and 1"})
+				return
+			}
+
+			sampleRate = &rate
 		}
-	})
 
-	r.DELETE("/admin/tag/:word", func(c *gin.Context) {
-		words := ExtractWords(c.Param("word"))
-		actions.WithWriteLock(func() {
-			for _, word := range words {
-				hash := HashWord(word)
-				actions.store.Replace(hash, []int32{})
+		var shadowRules []string
+		value, hasShadowRules := c.GetPostForm("shadow-rules")
+		if hasShadowRules {
+			shadowRules = parseRuleList(value)
+			if err := parser.ValidateOptimizerRules(shadowRules); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
 			}
+		}
+
+		actions.UpdateConfig(func(config *searchConfig) {
+			if value := c.PostForm("optimize"); value != "" {
+				config.UseOptimizer = value == "true"
+			}
+
+			if sampleRate != nil {
+				config.ShadowSampleRate = *sampleRate
+			}
+
+			if hasShadowRules {
+				config.ShadowRules = shadowRules
+			}
+		})
+
+		c.JSON(http.StatusOK, actions.Config())
+	})
+
+	r.GET("/admin/collisions", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"collisions":     actions.dict.Collisions(),
+			"legacyKeyCount": actions.dict.LegacyKeyCount(),
+		})
+	})
+
+	r.DELETE("/admin/item/:id", func(c *gin.Context) {
+		itemId, err := strconv.Atoi(c.Param("id"))
+		if err != nil || itemId <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+			return
+		}
+
+		start := time.Now()
+		changedKeyCount := actions.RemoveItems([]int{itemId})
+
+		c.JSON(http.StatusOK, gin.H{
+			"duration":    time.Since(start).String(),
+			"keysChanged": changedKeyCount,
 		})
 	})
 
+	r.DELETE("/admin/tag/:word", func(c *gin.Context) {
+		actions.RemoveTerms(ExtractWords(c.Param("word")))
+	})
+
 	logrus.Fatal(r.Run(httpListen))
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+type shadowQuery struct {
+	Query     string
+	OlderThan int32
+
+	// the ast as returned by the parser
+	Parsed *parser.Node
+
+	// the ast that was used to answer the users request
+	Actual *parser.Node
+
+	// optimizer rules for the shadow execution, nil means "no optimizer"
+	Rules []string
+}
+
+// Executes sampled queries a second time in the background using a different
+// optimizer configuration and compares the results. This never has any effect
+// on the results that are returned to the user.
+type shadowRunner struct {
+	actions *storeActions
+	queue   chan shadowQuery
+}
+
+func newShadowRunner(actions *storeActions, queueSize int) *shadowRunner {
+	runner := &shadowRunner{
+		actions: actions,
+		queue:   make(chan shadowQuery, queueSize),
+	}
+
+	go runner.run()
+	return runner
+}
+
+// Queues the query for shadow execution. If the queue is full, the
+// query is dropped instead of blocking the caller.
+func (runner *shadowRunner) Submit(query shadowQuery) {
+	select {
+	case runner.queue <- query:
+	default:
+		metricsShadowDropped.Inc(1)
+	}
+}
+
+func (runner *shadowRunner) run() {
+	for query := range runner.queue {
+		err := withRecovery("shadow", func() {
+			runner.execute(query)
+		})
+
+		if err != nil {
+			log.WithError(err).WithField("query", query.Query).Warn("Shadow execution failed")
+			metricsShadowError.Inc(1)
+		}
+	}
+}
+
+func (runner *shadowRunner) execute(query shadowQuery) {
+	candidate := query.Parsed
+	if query.Rules != nil {
+		candidate = parser.OptimizeWithRules(query.Parsed, query.Rules)
+	}
+
+	// nothing to compare if both trees are the same.
+	if candidate.EqualTo(query.Actual) {
+		return
+	}
+
+	metricsShadowExecuted.Inc(1)
+
+	// execute both queries while holding the same lock, so that
+	// updates to the store can not produce a false mismatch.
+	var actual, shadow []int32
+	runner.actions.WithReadLock(func() {
+		actual = runner.actions.executeQuery(query.Actual, query.OlderThan, false)
+		shadow = runner.actions.executeQuery(candidate, query.OlderThan, false)
+	})
+
+	if !equalInt32s(actual, shadow) {
+		metricsShadowMismatch.Inc(1)
+
+		actualJson, _ := json.Marshal(query.Actual)
+		shadowJson, _ := json.Marshal(candidate)
+
+		log.WithField("query", query.Query).
+			WithField("older", query.OlderThan).
+			WithField("actualAst", string(actualJson)).
+			WithField("shadowAst", string(shadowJson)).
+			WithField("actualCount", len(actual)).
+			WithField("shadowCount", len(shadow)).
+			Warn("Shadow execution produced a different result")
+	}
+}
+
+func equalInt32s(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/parser"
+)
+
+func newShadowTestActions() *storeActions {
+	sa := newTestActions()
+	sa.store.Replace(sa.dict.KeyOf("a"), []int32{-1})
+	sa.store.Replace(sa.dict.KeyOf("b"), []int32{-2})
+	return sa
+}
+
+func TestShadowReportsMismatch(t *testing.T) {
+	sa := newShadowTestActions()
+	runner := &shadowRunner{actions: sa}
+
+	mismatches := metricsShadowMismatch.Count()
+
+	// a broken optimizer answered the query "a" using the tree "b".
+	runner.execute(shadowQuery{
+		Query:  "a",
+		Parsed: parser.NewQueryNode("a"),
+		Actual: parser.NewQueryNode("b"),
+	})
+
+	if metricsShadowMismatch.Count() != mismatches+1 {
+		t.Error("Expected the different results to be reported")
+	}
+
+	// a different tree with the same result is not a mismatch.
+	runner.execute(shadowQuery{
+		Query:  "a",
+		Parsed: parser.NewQueryNode("a"),
+		Actual: parser.NewOpNode(parser.AND, parser.NewQueryNode("a"), parser.NewQueryNode("a")),
+	})
+
+	if metricsShadowMismatch.Count() != mismatches+1 {
+		t.Error("Expected no mismatch for the same results")
+	}
+}
+
+func TestShadowSkipsEqualTrees(t *testing.T) {
+	runner := &shadowRunner{actions: newShadowTestActions()}
+
+	executed := metricsShadowExecuted.Count()
+
+	runner.execute(shadowQuery{
+		Query:  "a",
+		Parsed: parser.NewQueryNode("a"),
+		Actual: parser.NewQueryNode("a"),
+		Rules:  parser.OptimizerRuleNames(),
+	})
+
+	if metricsShadowExecuted.Count() != executed {
+		t.Error("Expected no shadow execution for equal trees")
+	}
+}
+
+func TestShadowDropsQueriesIfQueueIsFull(t *testing.T) {
+	// the runner is not started, so nothing is taken from the queue.
+	runner := &shadowRunner{queue: make(chan shadowQuery, 1)}
+
+	dropped := metricsShadowDropped.Count()
+
+	runner.Submit(shadowQuery{Query: "a"})
+	runner.Submit(shadowQuery{Query: "b"})
+
+	if len(runner.queue) != 1 || metricsShadowDropped.Count() != dropped+1 {
+		t.Error("Expected the second query to be dropped")
+	}
+}
+
+func TestSearchSamplesShadowQueries(t *testing.T) {
+	sa := newShadowTestActions()
+	sa.shadow = &shadowRunner{queue: make(chan shadowQuery, 8)}
+
+	sa.SetConfig(searchConfig{UseOptimizer: true, ShadowSampleRate: 0})
+	sa.Search("a", 0, false)
+
+	if len(sa.shadow.queue) != 0 {
+		t.Error("Expected no shadow query with a sample rate of zero")
+	}
+
+	sa.SetConfig(searchConfig{UseOptimizer: true, ShadowSampleRate: 1})
+	sa.Search("a", 0, false)
+	sa.Search("a", 0, true)
+
+	if len(sa.shadow.queue) != 1 {
+		t.Errorf("Expected only the unshuffled query to be sampled, got %d", len(sa.shadow.queue))
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reads changes from files containing one json record per line:
+//
+//	{"type": "item", "id": 1, "updated": "2017-09-01T12:00:00Z", "username": "cha0s", "flags": 1, ...}
+//	{"type": "tag", "id": 1, "itemId": 1, "tag": "kadse", "confidence": 0.5}
+//	{"type": "deletedItem", "itemId": 1}
+//
+// The path can be a single file or a directory. Files of a directory are read
+// ordered by their names. New files and lines appended to the last file are
+// picked up by the next fetch. Deleted tags are not supported. A tag record
+// with a higher confidence indexes the tag, but tags are not removed when
+// their confidence drops below the threshold.
+type ndjsonSource struct {
+	path       string
+	maxRecords int
+}
+
+func newNdjsonSource(path string) *ndjsonSource {
+	return &ndjsonSource{path: path, maxRecords: 50000}
+}
+
+func (source *ndjsonSource) FetchChanges(state store.StoreState) (changeBatch, error) {
+	files, err := source.files()
+	if err != nil {
+		return changeBatch{}, err
+	}
+
+	var changes changeBatch
+
+	recordCount := 0
+	for _, file := range files {
+		if file < state.LastFile {
+			continue
+		}
+
+		var offset int64
+		if file == state.LastFile {
+			offset = state.LastFileOffset
+		}
+
+		offset, err := source.readFile(file, offset, &changes, &recordCount)
+		if err != nil {
+			return changeBatch{}, err
+		}
+
+		state.LastFile, state.LastFileOffset = file, offset
+
+		if recordCount >= source.maxRecords {
+			changes.More = true
+			break
+		}
+	}
+
+	changes.State = state
+	return changes, nil
+}
+
+// Returns the files to read, sorted by name.
+func (source *ndjsonSource) files() ([]string, error) {
+	st, err := os.Stat(source.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !st.IsDir() {
+		return []string{source.path}, nil
+	}
+
+	infos, err := ioutil.ReadDir(source.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".") {
+			files = append(files, filepath.Join(source.path, info.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// Reads complete lines of the file starting at the given offset and
+// returns the offset behind the last line that was read.
+func (source *ndjsonSource) readFile(file string, offset int64, changes *changeBatch, recordCount *int) (int64, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return offset, err
+	}
+
+	defer fp.Close()
+
+	if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReader(fp)
+	for *recordCount < source.maxRecords {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete line is read again by the next fetch.
+			return offset, nil
+		}
+
+		if err != nil {
+			return offset, err
+		}
+
+		lineOffset := offset
+		offset += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := changes.addRecord(line); err != nil {
+			log.WithError(err).
+				WithField("file", file).
+				WithField("offset", lineOffset).
+				Warn("Skipping invalid record")
+
+			metricsUpdaterError.Inc(1)
+			continue
+		}
+
+		*recordCount++
+	}
+
+	return offset, nil
+}
+
+func (changes *changeBatch) addRecord(line []byte) error {
+	var header struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(line, &header); err != nil {
+		return err
+	}
+
+	switch header.Type {
+	case "item":
+		var info postInfo
+		if err := json.Unmarshal(line, &info); err != nil {
+			return err
+		}
+
+		info.Username = strings.ToLower(info.Username)
+		changes.Items = append(changes.Items, info)
+
+	case "tag":
+		// tags without a confidence are always indexed.
+		info := tagInfo{Confidence: 1}
+		if err := json.Unmarshal(line, &info); err != nil {
+			return err
+		}
+
+		info.Tag = strings.ToLower(info.Tag)
+		changes.Tags = append(changes.Tags, info)
+
+	case "deletedItem":
+		var info deletedItemInfo
+		if err := json.Unmarshal(line, &info); err != nil {
+			return err
+		}
+
+		changes.DeletedItems = append(changes.DeletedItems, info.ItemId)
+
+	default:
+		return fmt.Errorf("Unknown record type '%s'", header.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+)
+
+func writeFile(t *testing.T, path, content string, flag int) {
+	fp, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fp.Close()
+
+	if _, err := fp.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNdjsonSourceReadsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeFile(t, filepath.Join(dir, "0001.json"), ""+
+		`{"type": "item", "id": 1, "username": "Cha0s", "flags": 1, "score": 250}`+"\n"+
+		`{"type": "tag", "id": 1, "itemId": 1, "tag": "Kadse"}`+"\n", os.O_TRUNC)
+
+	// the second line is not complete yet
+	writeFile(t, filepath.Join(dir, "0002.json"), ""+
+		`{"type": "tag", "id": 2, "itemId": 2, "tag": "kefer"}`+"\n"+
+		`{"type": "tag", "id": 3, "itemId": 2, `, os.O_TRUNC)
+
+	sa := newTestActions()
+	source := newNdjsonSource(dir)
+
+	sa.UpdateOnce(source)
+
+	if !hasItem(sa, "u:cha0s", 1) || !hasItem(sa, "s:200", 1) || !hasItem(sa, "kadse", 1) || !hasItem(sa, "kefer", 2) {
+		t.Fatal("Expected the records of both files in the store")
+	}
+
+	// complete the line and add a new file
+	writeFile(t, filepath.Join(dir, "0002.json"), `"tag": "repost"}`+"\n", os.O_APPEND)
+	writeFile(t, filepath.Join(dir, "0003.json"), `{"type": "deletedItem", "itemId": 1}`+"\n", os.O_TRUNC)
+
+	sa.UpdateOnce(source)
+
+	if !hasItem(sa, "f:repost", 2) {
+		t.Error("Expected the record appended to the file in the store")
+	}
+
+	if hasItem(sa, "kadse", 1) || hasItem(sa, store.AllTerm, 1) {
+		t.Error("Deleted item is still in the store")
+	}
+
+	if sa.storeState.LastFile != filepath.Join(dir, "0003.json") {
+		t.Errorf("Unexpected cursor %s", sa.storeState.LastFile)
+	}
+}
+
+func TestNdjsonSourceLimitsBatchSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tags.json")
+	writeFile(t, file, ""+
+		`{"type": "tag", "id": 1, "itemId": 1, "tag": "a1"}`+"\n"+
+		`invalid`+"\n"+
+		`{"type": "tag", "id": 2, "itemId": 2, "tag": "a2"}`+"\n"+
+		`{"type": "tag", "id": 3, "itemId": 3, "tag": "a3"}`+"\n", os.O_TRUNC)
+
+	source := newNdjsonSource(file)
+	source.maxRecords = 2
+
+	changes, err := source.FetchChanges(store.StoreState{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.More || len(changes.Tags) != 2 {
+		t.Fatalf("Expected a full batch with two tags, got %d tags", len(changes.Tags))
+	}
+
+	changes, err = source.FetchChanges(changes.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes.Tags) != 1 || changes.Tags[0].Tag != "a3" {
+		t.Errorf("Expected the last tag in the second batch, got %v", changes.Tags)
+	}
+}
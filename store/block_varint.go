@@ -0,0 +1,204 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Number of values in one block of the block varint codec.
+const varintBlockSize = 128
+
+const varintBlockHeaderSize = 8
+
+// Header of a block structured sequence. Each block has an entry
+// with its first value (int32) and its offset (uint32) in the data section.
+type blockIndex []byte
+
+func (index blockIndex) first(block int) int32 {
+	return int32(binary.LittleEndian.Uint32(index[varintBlockHeaderSize*block:]))
+}
+
+func (index blockIndex) offset(block int) int {
+	return int(binary.LittleEndian.Uint32(index[varintBlockHeaderSize*block+4:]))
+}
+
+func (index blockIndex) put(block int, first int32, offset int) {
+	binary.LittleEndian.PutUint32(index[varintBlockHeaderSize*block:], uint32(first))
+	binary.LittleEndian.PutUint32(index[varintBlockHeaderSize*block+4:], uint32(offset))
+}
+
+// Returns the last block in [from, blockCount) that starts with a value
+// not greater than val, or from-1 if there is no such block.
+func (index blockIndex) search(from, blockCount int, val int32) int {
+	rest := blockCount - from
+	return from + sort.Search(rest, func(i int) bool { return index.first(from+i) > val }) - 1
+}
+
+// Reads the item count and the block index from the start of the given bytes and
+// returns them together with the remaining data section.
+func readBlockIndex(bytes []byte, blockSize int) (int, int, blockIndex, []byte) {
+	count, n := binary.Uvarint(bytes)
+	if n <= 0 {
+		panic(errors.New("Could not decode item count."))
+	}
+
+	blockCount := (int(count) + blockSize - 1) / blockSize
+	index := blockIndex(bytes[n : n+varintBlockHeaderSize*blockCount])
+	return int(count), blockCount, index, bytes[n+len(index):]
+}
+
+// Returns the item count of a block structured sequence.
+func blockCount(bytes []byte) int {
+	count, n := binary.Uvarint(bytes)
+	if n <= 0 {
+		panic(errors.New("Could not decode item count."))
+	}
+
+	return int(count)
+}
+
+// Encodes the values as varint deltas in blocks of varintBlockSize values.
+// Layout: item count (uvarint), then one header per block with the first value
+// of the block (int32) and the offset of the block in the data section (uint32),
+// then the data section. The first value of a block is only stored in the header.
+func blockVarintEncode(values []int32) []byte {
+	blockCount := (len(values) + varintBlockSize - 1) / varintBlockSize
+
+	scratch := make([]byte, binary.MaxVarintLen32+varintBlockHeaderSize*blockCount+len(values)*binary.MaxVarintLen32)
+	written := binary.PutUvarint(scratch, uint64(len(values)))
+
+	header := blockIndex(scratch[written:])
+	data := header[varintBlockHeaderSize*blockCount:]
+
+	offset := 0
+	for block := 0; block < blockCount; block++ {
+		blockValues := values[block*varintBlockSize:]
+		if len(blockValues) > varintBlockSize {
+			blockValues = blockValues[:varintBlockSize]
+		}
+
+		header.put(block, blockValues[0], offset)
+
+		previous := blockValues[0]
+		for _, value := range blockValues[1:] {
+			offset += binary.PutVarint(data[offset:], int64(value-previous))
+			previous = value
+		}
+	}
+
+	return scratch[:written+varintBlockHeaderSize*blockCount+offset]
+}
+
+type blockVarintIterator struct {
+	count      int
+	blockCount int
+	header     blockIndex
+	data       []byte
+
+	block     int
+	remaining int
+	pos       int
+
+	next int32
+	more bool
+}
+
+func NewBlockVarintIterator(bytes []byte) ItemIterator {
+	count, blockCount, header, data := readBlockIndex(bytes, varintBlockSize)
+
+	it := &blockVarintIterator{
+		count:      count,
+		blockCount: blockCount,
+		header:     header,
+		data:       data,
+	}
+
+	if blockCount > 0 {
+		it.loadBlock(0)
+	}
+
+	return it
+}
+
+func (it *blockVarintIterator) loadBlock(block int) {
+	it.block = block
+	it.pos = it.header.offset(block)
+	it.next = it.header.first(block)
+	it.more = true
+
+	if block == it.blockCount-1 {
+		it.remaining = it.count - block*varintBlockSize - 1
+	} else {
+		it.remaining = varintBlockSize - 1
+	}
+}
+
+func (it *blockVarintIterator) HasMore() bool {
+	return it.more
+}
+
+func (it *blockVarintIterator) Peek() int32 {
+	return it.next
+}
+
+func (it *blockVarintIterator) Next() int32 {
+	current := it.next
+	it.advance()
+	return current
+}
+
+func (it *blockVarintIterator) advance() {
+	switch {
+	case it.remaining > 0:
+		delta, n := fastVarint32(it.data[it.pos:])
+		if n <= 0 {
+			panic(errors.New("Could not decode varint."))
+		}
+
+		it.pos += n
+		it.next += delta
+		it.remaining--
+
+	case it.block+1 < it.blockCount:
+		it.loadBlock(it.block + 1)
+
+	default:
+		it.more = false
+	}
+}
+
+func (it *blockVarintIterator) SkipUntil(val int32) {
+	if !it.more || it.next >= val {
+		return
+	}
+
+	// jump directly into the last block that starts with a value not greater than val.
+	if block := it.header.search(it.block+1, it.blockCount, val); block > it.block {
+		it.loadBlock(block)
+	}
+
+	for it.more && it.next < val {
+		it.advance()
+	}
+}
+
+func (it *blockVarintIterator) MaxSize() int {
+	return it.count
+}
+
+func (it *blockVarintIterator) ToSlice() []int32 {
+	slice := make([]int32, 0, it.count)
+	for block := 0; block < it.blockCount; block++ {
+		it.loadBlock(block)
+
+		slice = append(slice, it.next)
+		for it.remaining > 0 {
+			it.advance()
+			slice = append(slice, it.next)
+		}
+	}
+
+	it.more = false
+	return slice
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func blockVarintIter(values []int32) ItemIterator {
+	return NewBlockVarintIterator(blockVarintEncode(values))
+}
+
+func TestBlockVarintRoundtrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, count := range []int{1, varintBlockSize - 1, varintBlockSize, varintBlockSize + 1, 10000} {
+		values := randomItems(rng, count, 0.01)
+		testIter(t, iter(values...), blockVarintIter(values))
+		testIter(t, iter(values...), iter(IteratorToList(nil, blockVarintIter(values))...))
+	}
+}
+
+func TestBlockVarintSkipUntil(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(2)), 10000, 0.05)
+
+	it := blockVarintIter(values).(FastItemIterator)
+	targets := []int32{values[0], values[3], values[varintBlockSize] - 1, values[varintBlockSize],
+		values[varintBlockSize] + 1, values[5000], values[len(values)-1]}
+
+	for _, target := range targets {
+		it.SkipUntil(target)
+
+		expected := iter(values...)
+		IteratorSkipUntil(expected, target)
+
+		if it.Peek() != expected.Peek() {
+			t.Errorf("SkipUntil(%d) moved to %d, expected was %d", target, it.Peek(), expected.Peek())
+		}
+	}
+
+	it.SkipUntil(values[len(values)-1] + 1)
+	if it.HasMore() {
+		t.Error("SkipUntil behind the last value must exhaust the iterator")
+	}
+}
+
+func TestBlockVarintInAndIterator(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	large := randomItems(rng, 50000, 0.05)
+	small := randomItems(rng, 100, 0.0001)
+
+	testIter(t,
+		&andIterator{iter(large...), iter(small...)},
+		NewAndIterator(blockVarintIter(large), blockVarintIter(small)))
+}
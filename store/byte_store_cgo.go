@@ -0,0 +1,95 @@
+//go:build !purego
+// +build !purego
+
+package store
+
+// #cgo CXXFLAGS: -std=c++11 -O3
+// #include "sequence_c.h"
+import "C"
+import (
+	"runtime"
+	"unsafe"
+)
+
+type cppStore struct {
+	p C.store
+}
+
+// Creates a new byte store backed by the c++ implementation.
+func NewByteStore() ByteStore {
+	return newCppByteStore()
+}
+
+func newCppByteStore() *cppStore {
+	p := C.store_new()
+
+	store := &cppStore{p: p}
+	runtime.SetFinalizer(store, func(st *cppStore) {
+		C.store_destroy(st.p)
+	})
+
+	return store
+}
+
+func (store *cppStore) Push(key uint32, value byte) {
+	C.store_seq_push(store.p, C.uint32_t(key), C.uint8_t(value))
+}
+
+func (store *cppStore) PushN(key uint32, values []byte) {
+	if len(values) == 0 {
+		return
+	}
+
+	C.store_seq_push_n(store.p, C.uint32_t(key),
+		(*C.uint8_t)(&values[0]), C.int(len(values)))
+}
+
+func (store *cppStore) KeyCount() uint32 {
+	return uint32(C.store_length(store.p))
+}
+
+func (store *cppStore) Compact(key uint32) {
+	C.store_seq_compact(store.p, C.uint32_t(key))
+}
+
+func (store *cppStore) SeqLength(key uint32) uint32 {
+	return uint32(C.store_seq_length(store.p, C.uint32_t(key)))
+}
+
+func (store *cppStore) Remove(key uint32) {
+	C.store_remove_key(store.p, C.uint32_t(key))
+}
+
+func (store *cppStore) Clear(key uint32) {
+	C.store_clear_key(store.p, C.uint32_t(key))
+}
+
+func (store *cppStore) Contains(key uint32) bool {
+	return int(C.store_contains(store.p, C.uint32_t(key))) != 0
+}
+
+func (store *cppStore) MemorySize() ByteSize {
+	return ByteSize(C.store_memory_size(store.p))
+}
+
+// You my not hold on to this slice!
+func (store *cppStore) Get(key uint32) []byte {
+	bv := C.store_get(store.p, C.uint32_t(key))
+
+	length := int(bv.length)
+	if length == 0 {
+		return nil
+	}
+
+	return unsafeBytes(unsafe.Pointer(bv.data), length)
+}
+
+func (store *cppStore) Keys() []uint32 {
+	keys := make([]uint32, store.KeyCount())
+	if len(keys) == 0 {
+		return keys
+	}
+
+	n := int(C.store_keys(store.p, (*C.uint32_t)(unsafe.Pointer(&keys[0])), C.int(cap(keys))))
+	return keys[:n]
+}
@@ -0,0 +1,10 @@
+//go:build !purego
+// +build !purego
+
+package store
+
+func init() {
+	byteStoreImplementations["cpp"] = func() ByteStore {
+		return newCppByteStore()
+	}
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"sort"
+)
+
+// Sequences up to this size are allocated from slabs, bigger ones are
+// allocated directly on the heap.
+const maxSlabSequenceSize = 4096
+
+// Size of one slab. Every slab is carved into sequences of one size class.
+const slabSize = 64 * 1024
+
+// Smallest size class, this matches the inline capacity of the c++ sequence.
+const minSizeClass = 16
+
+// Number of size classes from minSizeClass up to maxSlabSequenceSize.
+const sizeClassCount = 9
+
+// Estimated overhead of a map entry including the slice header.
+const goStoreEntryOverhead = 40
+
+type goStore struct {
+	sequences map[uint32][]byte
+	arena     slabArena
+}
+
+// Creates a new byte store that is implemented in pure go.
+func newGoByteStore() *goStore {
+	return &goStore{
+		sequences: make(map[uint32][]byte),
+	}
+}
+
+func (store *goStore) Push(key uint32, value byte) {
+	store.PushN(key, []byte{value})
+}
+
+func (store *goStore) PushN(key uint32, values []byte) {
+	seq := store.sequences[key]
+
+	if required := len(seq) + len(values); required > cap(seq) {
+		// grow by 30% like the c++ implementation does.
+		grown := store.arena.allocate(maxInt(required, 8+13*cap(seq)/10))
+		grown = append(grown, seq...)
+
+		store.arena.release(seq)
+		seq = grown
+	}
+
+	store.sequences[key] = append(seq, values...)
+}
+
+func (store *goStore) Contains(key uint32) bool {
+	_, ok := store.sequences[key]
+	return ok
+}
+
+// You my not hold on to this slice!
+func (store *goStore) Get(key uint32) []byte {
+	seq := store.sequences[key]
+	if len(seq) == 0 {
+		return nil
+	}
+
+	return seq
+}
+
+func (store *goStore) Remove(key uint32) {
+	if seq, ok := store.sequences[key]; ok {
+		store.arena.release(seq)
+		delete(store.sequences, key)
+	}
+}
+
+func (store *goStore) Compact(key uint32) {
+	seq, ok := store.sequences[key]
+	if !ok || store.arena.capacityFor(len(seq)) >= cap(seq) {
+		return
+	}
+
+	compacted := append(store.arena.allocate(len(seq)), seq...)
+	store.arena.release(seq)
+	store.sequences[key] = compacted
+}
+
+func (store *goStore) Clear(key uint32) {
+	store.arena.release(store.sequences[key])
+	store.sequences[key] = nil
+}
+
+func (store *goStore) KeyCount() uint32 {
+	return uint32(len(store.sequences))
+}
+
+func (store *goStore) Keys() []uint32 {
+	keys := make([]uint32, 0, len(store.sequences))
+	for key := range store.sequences {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
+
+func (store *goStore) MemorySize() ByteSize {
+	return ByteSize(store.arena.memorySize() + goStoreEntryOverhead*len(store.sequences))
+}
+
+// Allocates small byte slices from bigger slabs. Each slab serves only one
+// size class, released slices are put onto a free list and are reused for
+// the next allocation of the same class.
+type slabArena struct {
+	current [sizeClassCount][]byte
+	free    [sizeClassCount][][]byte
+
+	slabBytes  int
+	largeBytes int
+}
+
+// Returns the index of the smallest size class that can hold n bytes.
+func sizeClassOf(n int) int {
+	class := 0
+	for size := minSizeClass; size < n; size *= 2 {
+		class++
+	}
+
+	return class
+}
+
+func sizeOfClass(class int) int {
+	return minSizeClass << uint(class)
+}
+
+// Returns the capacity of a slice that would be allocated for n bytes.
+func (arena *slabArena) capacityFor(n int) int {
+	if n > maxSlabSequenceSize {
+		return n
+	}
+
+	return sizeOfClass(sizeClassOf(n))
+}
+
+// Allocates an empty slice with a capacity of at least n bytes.
+func (arena *slabArena) allocate(n int) []byte {
+	if n > maxSlabSequenceSize {
+		arena.largeBytes += n
+		return make([]byte, 0, n)
+	}
+
+	class := sizeClassOf(n)
+	size := sizeOfClass(class)
+
+	if free := arena.free[class]; len(free) > 0 {
+		slice := free[len(free)-1]
+		arena.free[class] = free[:len(free)-1]
+		return slice[:0]
+	}
+
+	if len(arena.current[class]) < size {
+		arena.current[class] = make([]byte, slabSize)
+		arena.slabBytes += slabSize
+	}
+
+	slab := arena.current[class]
+	arena.current[class] = slab[size:]
+	return slab[0:0:size]
+}
+
+// Returns a slice previously allocated with allocate to the arena.
+func (arena *slabArena) release(slice []byte) {
+	capacity := cap(slice)
+	switch {
+	case capacity == 0:
+		return
+
+	case capacity > maxSlabSequenceSize:
+		arena.largeBytes -= capacity
+
+	default:
+		class := sizeClassOf(capacity)
+		arena.free[class] = append(arena.free[class], slice[:0])
+	}
+}
+
+func (arena *slabArena) memorySize() int {
+	return arena.slabBytes + arena.largeBytes
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,9 @@
+//go:build purego
+// +build purego
+
+package store
+
+// Creates a new byte store backed by the pure go implementation.
+func NewByteStore() ByteStore {
+	return newGoByteStore()
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+// All byte store implementations that need to pass the conformance tests.
+var byteStoreImplementations = map[string]func() ByteStore{
+	"go": func() ByteStore {
+		return newGoByteStore()
+	},
+}
+
+func forEachByteStore(t *testing.T, test func(t *testing.T, store ByteStore)) {
+	for name, factory := range byteStoreImplementations {
+		t.Run(name, func(t *testing.T) {
+			test(t, factory())
+		})
+	}
+}
+
+func TestByteStorePush(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		var expected []byte
+		for idx := 0; idx < 10000; idx++ {
+			store.Push(7, byte(idx))
+			expected = append(expected, byte(idx))
+		}
+
+		if !bytes.Equal(store.Get(7), expected) {
+			t.Error("Store did not return the pushed bytes")
+		}
+	})
+}
+
+func TestByteStorePushN(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		store.PushN(1, []byte{1, 2, 3})
+		store.PushN(1, []byte{})
+		store.PushN(1, bytes.Repeat([]byte{4}, 5000))
+
+		expected := append([]byte{1, 2, 3}, bytes.Repeat([]byte{4}, 5000)...)
+		if !bytes.Equal(store.Get(1), expected) {
+			t.Error("Store did not return the pushed bytes")
+		}
+	})
+}
+
+func TestByteStoreKeysAreSorted(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		if len(store.Keys()) != 0 || store.KeyCount() != 0 {
+			t.Error("New store is not empty")
+		}
+
+		for _, key := range []uint32{9, 3, 0, 0xffffffff, 5} {
+			store.Push(key, 1)
+		}
+
+		keys := store.Keys()
+		expected := []uint32{0, 3, 5, 9, 0xffffffff}
+		if len(keys) != len(expected) || store.KeyCount() != uint32(len(expected)) {
+			t.Fatalf("Expected %d keys, got %d", len(expected), len(keys))
+		}
+
+		for idx := range keys {
+			if keys[idx] != expected[idx] {
+				t.Errorf("Expected key %d at index %d, got %d", expected[idx], idx, keys[idx])
+			}
+		}
+	})
+}
+
+func TestByteStoreClearKeepsKey(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		store.PushN(1, bytes.Repeat([]byte{1}, 100))
+		store.Clear(1)
+
+		if !store.Contains(1) {
+			t.Error("Cleared key must still be contained")
+		}
+
+		if len(store.Get(1)) != 0 {
+			t.Error("Cleared key must be empty")
+		}
+
+		store.PushN(1, []byte{5, 6})
+		if !bytes.Equal(store.Get(1), []byte{5, 6}) {
+			t.Error("Store did not return the bytes pushed after clear")
+		}
+	})
+}
+
+func TestByteStoreRemove(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		store.PushN(1, []byte{1, 2, 3})
+		store.PushN(2, []byte{4})
+		store.Remove(1)
+		store.Remove(3)
+
+		if store.Contains(1) || len(store.Get(1)) != 0 {
+			t.Error("Removed key is still in the store")
+		}
+
+		if store.KeyCount() != 1 || !bytes.Equal(store.Get(2), []byte{4}) {
+			t.Error("Removing a key changed another key")
+		}
+	})
+}
+
+func TestByteStoreCompact(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		data := bytes.Repeat([]byte{1, 2, 3}, 3000)
+		store.PushN(1, data)
+
+		before := store.MemorySize()
+		store.Compact(1)
+
+		if store.MemorySize() > before {
+			t.Error("Compact increased the memory size")
+		}
+
+		if !bytes.Equal(store.Get(1), data) {
+			t.Error("Compact changed the data")
+		}
+	})
+}
+
+func TestByteStoreMemorySize(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		for key := uint32(0); key < 1000; key++ {
+			store.PushN(key, bytes.Repeat([]byte{1}, 100))
+			store.Compact(key)
+		}
+
+		size := store.MemorySize()
+		if size < 100*1000 || size > 300*1000 {
+			t.Errorf("Unexpected memory size %s for 100kb of data", size)
+		}
+	})
+}
+
+func TestByteStoreSequenceLargerThan16MB(t *testing.T) {
+	forEachByteStore(t, func(t *testing.T, store ByteStore) {
+		data := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 4*1024*1024)
+		store.PushN(1, data)
+		store.Compact(1)
+
+		if !bytes.Equal(store.Get(1), data) {
+			t.Error("Store did not return the complete sequence")
+		}
+	})
+}
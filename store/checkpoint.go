@@ -7,17 +7,59 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"time"
+
+	"github.com/cznic/sortutil"
 )
 
 var byteOrder = binary.BigEndian
 
 type StoreState struct {
-	LastTagId          int
+	// Version of the checkpoint format the state was read from.
+	Version int
+
+	LastTagId int
+
+	// Cursor of the item updates. States written before the id was added
+	// have an id of zero and read all items with the last update time again.
 	LastItemUpdateTime time.Time
+	LastItemId         int
+
+	LastDeletedTagId  int
+	LastDeletedItemId int
+
+	// Cursor of the changes of the tag confidence.
+	LastTagConfidenceId int
+
+	// Cursor of the file based update source.
+	LastFile       string
+	LastFileOffset int64
+
+	// Hash of the rules the derived terms were built with. Empty for
+	// states written before the rules were configurable.
+	DerivationRulesHash string
+
+	// Confidence settings the tags were indexed with. States written before
+	// the settings were added indexed all tags, like the zero values.
+	TagConfidenceThreshold float64
+	IndexLowConfidenceTags bool
+
+	// Ids of the most recently ingested events, oldest first. Only set
+	// in checkpoints, the ids are kept by the store while running.
+	IngestedEventIds []string `json:",omitempty"`
 }
 
-func WriteCheckpoint(writer io.Writer, state StoreState, store IterStore) error {
+// Version 0: item ids were pushed with 24 bit precision and wrapped around.
+// Version 1: item ids use the full 32 bit range.
+// Version 2: keys are taken from a dictionary that is stored after the keys.
+// Version 3: an optional forward index is stored after the dictionary.
+const CurrentCheckpointVersion = 3
+
+// Writes the store with its dictionary. The forward index is optional and can be nil.
+func WriteCheckpoint(writer io.Writer, state StoreState, store IterStore, dict *Dictionary, index *ForwardIndex) error {
+	state.Version = CurrentCheckpointVersion
+
 	{
 		bytes, err := json.Marshal(state)
 		if err != nil {
@@ -55,10 +97,47 @@ func WriteCheckpoint(writer io.Writer, state StoreState, store IterStore) error
 		}
 	}
 
+	if err := writeDictionary(writer, dict); err != nil {
+		return err
+	}
+
+	hasIndex := index != nil
+	if err := binary.Write(writer, byteOrder, hasIndex); err != nil {
+		return err
+	}
+
+	if hasIndex {
+		return writeForwardIndex(writer, index)
+	}
+
 	return nil
 }
 
-func WriteCheckpointFile(filename string, state StoreState, store IterStore) error {
+func writeDictionary(writer io.Writer, dict *Dictionary) (err error) {
+	if err := binary.Write(writer, byteOrder, uint32(dict.Len())); err != nil {
+		return err
+	}
+
+	dict.ForEach(func(term string, key uint32) {
+		if err != nil {
+			return
+		}
+
+		if err = binary.Write(writer, byteOrder, key); err != nil {
+			return
+		}
+
+		if err = binary.Write(writer, byteOrder, uint32(len(term))); err != nil {
+			return
+		}
+
+		_, err = io.WriteString(writer, term)
+	})
+
+	return
+}
+
+func WriteCheckpointFile(filename string, state StoreState, store IterStore, dict *Dictionary, index *ForwardIndex) error {
 	tempname := fmt.Sprintf("%s.%d", filename, time.Now().UnixNano())
 	fp, err := os.Create(tempname)
 	if err != nil {
@@ -71,7 +150,10 @@ func WriteCheckpointFile(filename string, state StoreState, store IterStore) err
 	writer := bufio.NewWriterSize(fp, 16*1024)
 
 	// write the store now.
-	WriteCheckpoint(writer, state, store)
+	if err := WriteCheckpoint(writer, state, store, dict, index); err != nil {
+		return err
+	}
+
 	if err := writer.Flush(); err != nil {
 		return err
 	}
@@ -85,7 +167,9 @@ func WriteCheckpointFile(filename string, state StoreState, store IterStore) err
 	return os.Rename(tempname, filename)
 }
 
-func ReadCheckpoint(reader io.Reader, state *StoreState, store IterStore) error {
+// Reads a checkpoint into the store and the dictionary. If a forward index is given
+// and the checkpoint does not contain one, it is built from the store.
+func ReadCheckpoint(reader io.Reader, state *StoreState, store IterStore, dict *Dictionary, index *ForwardIndex) error {
 	{
 		var jsonLength uint32
 		if err := binary.Read(reader, byteOrder, &jsonLength); err != nil {
@@ -122,13 +206,115 @@ func ReadCheckpoint(reader io.Reader, state *StoreState, store IterStore) error
 			return err
 		}
 
+		if state.Version < 1 {
+			values = unwrapInt24Values(values)
+		}
+
 		store.Replace(key, values)
 	}
 
+	if state.Version >= 2 {
+		if err := readDictionary(reader, dict); err != nil {
+			return err
+		}
+	} else {
+		migrateAllTerm(store, dict)
+	}
+
+	// keys without a term were created by hashing the term.
+	dict.SetLegacyKeys(store.Keys())
+
+	var hasIndex bool
+	if state.Version >= 3 {
+		if err := binary.Read(reader, byteOrder, &hasIndex); err != nil {
+			return err
+		}
+
+		if hasIndex {
+			if err := readForwardIndex(reader, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	if index != nil && !hasIndex {
+		index.AddStore(store)
+	}
+
+	state.Version = CurrentCheckpointVersion
 	return nil
 }
 
-func ReadCheckpointFile(filename string, state *StoreState, store IterStore) error {
+func readDictionary(reader io.Reader, dict *Dictionary) error {
+	var termCount uint32
+	if err := binary.Read(reader, byteOrder, &termCount); err != nil {
+		return err
+	}
+
+	var maxKey uint32
+	for idx := uint32(0); idx < termCount; idx++ {
+		var key, termLength uint32
+		if err := binary.Read(reader, byteOrder, &key); err != nil {
+			return err
+		}
+
+		if err := binary.Read(reader, byteOrder, &termLength); err != nil {
+			return err
+		}
+
+		term := make([]byte, termLength)
+		if _, err := io.ReadFull(reader, term); err != nil {
+			return err
+		}
+
+		dict.add(string(term), key)
+
+		if key > maxKey {
+			maxKey = key
+		}
+	}
+
+	// new keys are allocated behind the loaded ones, without scanning them again.
+	dict.allocateAfter(maxKey)
+	return nil
+}
+
+// Older checkpoints store the items of the virtual term "__all" in key zero.
+// Move them to a key from the dictionary.
+func migrateAllTerm(store IterStore, dict *Dictionary) {
+	values := IteratorToList(nil, store.GetIterator(0))
+	store.Replace(0, nil)
+
+	// the new key must not be taken from the legacy keys.
+	dict.SetLegacyKeys(store.Keys())
+
+	if len(values) > 0 {
+		store.Replace(dict.addNew(AllTerm), values)
+	}
+}
+
+// Item ids are stored negated, so a positive value can only be the result of
+// an id between 2^23 and 2^24 that wrapped around when it was stored as int24.
+// Those values are mapped back to their real value. Ids above 2^24 can not be
+// recovered, they are indistinguishable from smaller ids.
+func unwrapInt24Values(values []int32) []int32 {
+	wrapped := false
+	for idx, value := range values {
+		if value > 0 {
+			values[idx] = value - 1<<24
+			wrapped = true
+		}
+	}
+
+	if wrapped {
+		sort.Sort(sortutil.Int32Slice(values))
+		values = values[:sortutil.Dedupe(sortutil.Int32Slice(values))]
+	}
+
+	return values
+}
+
+func ReadCheckpointFile(filename string, state *StoreState, store IterStore, dict *Dictionary, index *ForwardIndex) error {
 	fp, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -136,5 +322,5 @@ func ReadCheckpointFile(filename string, state *StoreState, store IterStore) err
 
 	defer fp.Close()
 
-	return ReadCheckpoint(bufio.NewReaderSize(fp, 16*1024), state, store)
+	return ReadCheckpoint(bufio.NewReaderSize(fp, 16*1024), state, store, dict, index)
 }
@@ -3,7 +3,8 @@ package store
 import (
 	"encoding/binary"
 	"errors"
-	"unsafe"
+	"fmt"
+	"math/bits"
 )
 
 type SequenceCodec interface {
@@ -11,6 +12,10 @@ type SequenceCodec interface {
 	CanAppend() bool
 	Decode(bytes []byte) ItemIterator
 	Encode(values []int32) []byte
+
+	// Returns the exact number of items in the encoded bytes
+	// without decoding the sequence.
+	Count(bytes []byte) int
 }
 
 type int24Codec struct{}
@@ -27,9 +32,17 @@ func (*int24Codec) Decode(bytes []byte) ItemIterator {
 	return NewInt24Iterator(bytes)
 }
 
+func (*int24Codec) Count(bytes []byte) int {
+	return len(bytes) / 3
+}
+
 func (*int24Codec) Encode(values []int32) []byte {
 	scratch := make([]byte, len(values)*3)
 	for idx, value := range values {
+		if value < minInt24 || value > maxInt24 {
+			panic(fmt.Errorf("Value %d does not fit into 24 bits", value))
+		}
+
 		bytes := int24ToBytes(value)
 
 		o := 3 * idx
@@ -52,13 +65,20 @@ func (*varintCodec) CanAppend() bool {
 }
 
 func (*varintCodec) Decode(bytes []byte) ItemIterator {
-	return NewDecompressingIterator(bytes)
+	count, n := binary.Uvarint(bytes)
+	return newDecompressingIterator(bytes[n:], int(count))
+}
+
+func (*varintCodec) Count(bytes []byte) int {
+	count, _ := binary.Uvarint(bytes)
+	return int(count)
 }
 
+// Layout: item count (uvarint) followed by the deltas as varints.
 func (*varintCodec) Encode(values []int32) []byte {
-	scratch := make([]byte, len(values)*binary.MaxVarintLen32)
+	scratch := make([]byte, (len(values)+1)*binary.MaxVarintLen32)
 
-	var written int
+	written := binary.PutUvarint(scratch, uint64(len(values)))
 	previous := int32(0)
 	for _, value := range values {
 		n := binary.PutVarint(scratch[written:], int64(value-previous))
@@ -76,21 +96,93 @@ func (*int32Codec) Id() byte {
 }
 
 func (*int32Codec) CanAppend() bool {
-	return false
+	return true
 }
 
 func (*int32Codec) Decode(bytes []byte) ItemIterator {
 	return NewInt32Iterator(bytes)
 }
 
+func (*int32Codec) Count(bytes []byte) int {
+	return len(bytes) / 4
+}
+
 func (*int32Codec) Encode(values []int32) []byte {
-	byteCount := 4 * len(values)
-	return (*[1 << 24]byte)(unsafe.Pointer(&values[0]))[:byteCount:byteCount]
+	return int32sAsBytes(values)
+}
+
+type roaringCodec struct{}
+
+func (*roaringCodec) Id() byte {
+	return 5
+}
+
+func (*roaringCodec) CanAppend() bool {
+	return false
+}
+
+func (*roaringCodec) Decode(bytes []byte) ItemIterator {
+	return NewRoaringIterator(bytes)
+}
+
+func (*roaringCodec) Count(bytes []byte) int {
+	return roaringCount(bytes)
+}
+
+func (*roaringCodec) Encode(values []int32) []byte {
+	return roaringEncode(values)
+}
+
+type blockVarintCodec struct{}
+
+func (*blockVarintCodec) Id() byte {
+	return 6
+}
+
+func (*blockVarintCodec) CanAppend() bool {
+	return false
+}
+
+func (*blockVarintCodec) Decode(bytes []byte) ItemIterator {
+	return NewBlockVarintIterator(bytes)
+}
+
+func (*blockVarintCodec) Count(bytes []byte) int {
+	return blockCount(bytes)
+}
+
+func (*blockVarintCodec) Encode(values []int32) []byte {
+	return blockVarintEncode(values)
+}
+
+type pforCodec struct{}
+
+func (*pforCodec) Id() byte {
+	return 7
+}
+
+func (*pforCodec) CanAppend() bool {
+	return false
+}
+
+func (*pforCodec) Decode(bytes []byte) ItemIterator {
+	return NewPForIterator(bytes)
+}
+
+func (*pforCodec) Count(bytes []byte) int {
+	return blockCount(bytes)
+}
+
+func (*pforCodec) Encode(values []int32) []byte {
+	return pforEncode(values)
 }
 
 var int24CodecInstance = &int24Codec{}
 var varintCodecInstance = &varintCodec{}
 var int32CodecInstance = &int32Codec{}
+var roaringCodecInstance = &roaringCodec{}
+var blockVarintCodecInstance = &blockVarintCodec{}
+var pforCodecInstance = &pforCodec{}
 
 func SequenceCodecById(id byte) SequenceCodec {
 	switch {
@@ -103,15 +195,133 @@ func SequenceCodecById(id byte) SequenceCodec {
 	case id == 4:
 		return int32CodecInstance
 
+	case id == 5:
+		return roaringCodecInstance
+
+	case id == 6:
+		return blockVarintCodecInstance
+
+	case id == 7:
+		return pforCodecInstance
+
 	default:
 		panic(errors.New("unknown codec"))
 	}
 }
 
-func OptimalCodec(itemCount int) SequenceCodec {
-	if itemCount > 100000 {
-		return int32CodecInstance
-	} else {
-		return varintCodecInstance
+type CodecPolicy string
+
+const (
+	// Use the codec that produces the smallest encoding.
+	CodecPolicySmallest CodecPolicy = "smallest"
+
+	// Use the fastest codec that is not much bigger than the smallest one.
+	CodecPolicyFastest CodecPolicy = "fastest"
+)
+
+// The policy used to select a codec when replacing a sequence. This should only
+// be changed during startup, before the stores are used.
+var DefaultCodecPolicy = CodecPolicySmallest
+
+// For the fastest policy, a codec may produce an encoding this many
+// times bigger than the smallest one.
+const fastestCodecMaxOverhead = 2
+
+// All codecs that can be used for a sequence that does not need to be
+// appendable, ordered from the fastest to the slowest one.
+var candidateCodecs = []SequenceCodec{
+	int32CodecInstance,
+	roaringCodecInstance,
+	pforCodecInstance,
+	blockVarintCodecInstance,
+	varintCodecInstance,
+}
+
+func ParseCodecPolicy(value string) (CodecPolicy, error) {
+	switch policy := CodecPolicy(value); policy {
+	case CodecPolicySmallest, CodecPolicyFastest:
+		return policy, nil
+
+	default:
+		return "", fmt.Errorf("Unknown codec policy '%s'", value)
 	}
 }
+
+func OptimalCodec(values []int32) SequenceCodec {
+	codec, _ := EncodeOptimal(values, DefaultCodecPolicy)
+	return codec
+}
+
+// On updates, the current codec of a sequence is kept as long as its encoding
+// is at most this many times bigger than the estimated smallest encoding.
+const keepCodecMaxOverhead = 2
+
+// Encodings up to this size always keep their codec, the estimate is
+// not precise for short sequences.
+const keepCodecMinSize = 64
+
+// Estimates the size of the smallest encoding of the sorted values using
+// only their count and range.
+func estimateSmallestSize(values []int32) int {
+	count := len(values)
+	if count == 0 {
+		return 0
+	}
+
+	valueRange := int64(values[count-1]) - int64(values[0]) + 1
+
+	// delta encodings need about the bits of the average gap for each value,
+	// a bitmap needs one bit for each value in the range.
+	deltaSize := (count*bits.Len64(uint64(valueRange/int64(count))) + 7) / 8
+	bitmapSize := int((valueRange + 7) / 8)
+
+	return min(4*count, deltaSize, bitmapSize)
+}
+
+func isCandidateCodec(codec SequenceCodec) bool {
+	for _, candidate := range candidateCodecs {
+		if codec == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Encodes the values using the current codec of a sequence, if the encoding
+// is not much bigger than the estimated smallest encoding. Otherwise a new
+// codec is selected using EncodeOptimal. The current codec may be nil.
+func EncodeKeepingCodec(current SequenceCodec, values []int32, policy CodecPolicy) (SequenceCodec, []byte) {
+	if isCandidateCodec(current) {
+		bytes := current.Encode(values)
+		if len(bytes) <= keepCodecMinSize || len(bytes) <= keepCodecMaxOverhead*estimateSmallestSize(values) {
+			return current, bytes
+		}
+	}
+
+	return EncodeOptimal(values, policy)
+}
+
+// Encodes the values with every candidate codec and selects the best
+// encoding based on the given policy.
+func EncodeOptimal(values []int32, policy CodecPolicy) (SequenceCodec, []byte) {
+	encoded := make([][]byte, len(candidateCodecs))
+
+	smallest := 0
+	for idx, codec := range candidateCodecs {
+		encoded[idx] = codec.Encode(values)
+		if len(encoded[idx]) < len(encoded[smallest]) {
+			smallest = idx
+		}
+	}
+
+	if policy == CodecPolicyFastest {
+		for idx := range candidateCodecs {
+			if len(encoded[idx]) <= fastestCodecMaxOverhead*len(encoded[smallest]) {
+				return candidateCodecs[idx], encoded[idx]
+			}
+		}
+	}
+
+	return candidateCodecs[smallest], encoded[smallest]
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeOptimalSmallest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, density := range []float64{0.0001, 0.05, 0.9} {
+		values := randomItems(rng, 20000, density)
+
+		codec, bytes := EncodeOptimal(values, CodecPolicySmallest)
+		for _, candidate := range candidateCodecs {
+			if size := len(candidate.Encode(values)); size < len(bytes) {
+				t.Errorf("Codec %d is smaller than the selected codec %d", candidate.Id(), codec.Id())
+			}
+		}
+
+		testIter(t, iter(values...), codec.Decode(bytes))
+	}
+}
+
+func TestEncodeOptimalFastest(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(2)), 20000, 0.9)
+
+	// int32 is the fastest, but much bigger than a bitmap for dense lists.
+	codec, _ := EncodeOptimal(values, CodecPolicyFastest)
+	if codec != roaringCodecInstance {
+		t.Errorf("Expected the roaring codec for a dense list, got %d", codec.Id())
+	}
+}
+
+func TestParseCodecPolicy(t *testing.T) {
+	if policy, err := ParseCodecPolicy("fastest"); err != nil || policy != CodecPolicyFastest {
+		t.Error("Could not parse the fastest policy")
+	}
+
+	if _, err := ParseCodecPolicy("unknown"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestCodecsSupportFullInt32Range(t *testing.T) {
+	values := []int32{-1 << 31, -20000000, -1 << 24, -(1 << 23) - 1, -1 << 23, -1, 0, 1 << 30, 1<<31 - 1}
+	for _, codec := range candidateCodecs {
+		testIter(t, iter(values...), codec.Decode(codec.Encode(values)))
+	}
+}
+
+func TestInt32CodecWithMoreThan4MillionItems(t *testing.T) {
+	values := make([]int32, 5*1024*1024)
+	for idx := range values {
+		values[idx] = int32(idx - len(values))
+	}
+
+	bytes := int32CodecInstance.Encode(values)
+	if len(bytes) != 4*len(values) {
+		t.Fatalf("Expected %d bytes, got %d", 4*len(values), len(bytes))
+	}
+
+	it := int32CodecInstance.Decode(bytes)
+	if it.MaxSize() != len(values) {
+		t.Errorf("Expected %d items, got %d", len(values), it.MaxSize())
+	}
+
+	IteratorSkipUntil(it, -1)
+	if it.Next() != -1 || it.HasMore() {
+		t.Error("Could not iterate to the end of the sequence")
+	}
+
+	testIter(t, iter(values...), iter(IteratorToList(nil, int32CodecInstance.Decode(bytes))...))
+}
+
+func TestIterStoreWithMoreThan4MillionItems(t *testing.T) {
+	values := make([]int32, 5*1024*1024)
+	for idx := range values {
+		values[idx] = int32(3*idx - 3*len(values))
+	}
+
+	for name, factory := range byteStoreImplementations {
+		store := NewIterStore(factory())
+		store.Replace(1, values)
+
+		if store.Cardinality(1) != len(values) {
+			t.Errorf("%s: Expected cardinality %d, got %d", name, len(values), store.Cardinality(1))
+		}
+
+		testIter(t, iter(values...), store.GetIterator(1))
+	}
+}
+
+func TestReplaceKeepsCurrentCodec(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	values := randomItems(rng, 2000, 0.00001)
+
+	// int32 is bigger than the optimal encoding, but close enough to be kept.
+	if codec, _ := EncodeOptimal(values, CodecPolicySmallest); codec == int32CodecInstance {
+		t.Fatal("Expected a different optimal codec for the test values")
+	}
+
+	store := &iterStore{newGoByteStore()}
+	store.replaceEncoded(1, int32CodecInstance, int32CodecInstance.Encode(values))
+
+	store.Replace(1, values[1:])
+	if store.CodecOf(1) != int32CodecInstance {
+		t.Errorf("Expected the current codec to be kept, got %d", store.CodecOf(1).Id())
+	}
+
+	testIter(t, iter(values[1:]...), store.GetIterator(1))
+}
+
+func TestReplaceSelectsCodecIfCurrentIsTooBig(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(4)), 20000, 0.9)
+
+	store := &iterStore{newGoByteStore()}
+	store.replaceEncoded(1, int32CodecInstance, int32CodecInstance.Encode(values))
+
+	// a dense list is much smaller using an other codec.
+	store.Replace(1, values[1:])
+	if optimal, _ := EncodeOptimal(values[1:], DefaultCodecPolicy); store.CodecOf(1) != optimal {
+		t.Errorf("Expected the optimal codec %d, got %d", optimal.Id(), store.CodecOf(1).Id())
+	}
+
+	testIter(t, iter(values[1:]...), store.GetIterator(1))
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// The virtual term that contains every item.
+const AllTerm = "__all"
+
+// Maps terms to the keys of their posting lists. Every term gets a key of
+// its own, so there are no collisions between terms. Key zero is never used.
+//
+// Stores written before the dictionary existed used a hash of the term as key.
+// Keys of such a legacy store are adopted by the first term that maps to them.
+// If another term later maps to an adopted key, a collision is reported and the
+// term gets a new key.
+type Dictionary struct {
+	lock    sync.RWMutex
+	keys    map[string]uint32
+	terms   map[uint32]string
+	nextKey uint32
+
+	legacyHasher Hasher
+	legacyKeys   map[uint32]bool
+	collisions   map[uint32][]string
+}
+
+func NewDictionary(legacyHasher Hasher) *Dictionary {
+	return &Dictionary{
+		keys:         make(map[string]uint32),
+		terms:        make(map[uint32]string),
+		nextKey:      1,
+		legacyHasher: legacyHasher,
+		legacyKeys:   make(map[uint32]bool),
+		collisions:   make(map[uint32][]string),
+	}
+}
+
+// Returns the key of the term, if the term is known. A legacy key of the term
+// is returned, but not adopted, so looking up a term never changes the
+// dictionary. Legacy keys are only adopted by KeyOf.
+func (dict *Dictionary) Lookup(term string) (key uint32, ok bool) {
+	dict.lock.RLock()
+	defer dict.lock.RUnlock()
+
+	if key, ok = dict.keys[term]; ok {
+		return
+	}
+
+	if len(dict.legacyKeys) > 0 {
+		key = dict.legacyHasher(term)
+		ok = dict.legacyKeys[key]
+	}
+
+	return
+}
+
+// Returns the key of the term. A new key is allocated for unknown terms.
+func (dict *Dictionary) KeyOf(term string) (key uint32) {
+	dict.lock.RLock()
+	key, ok := dict.keys[term]
+	dict.lock.RUnlock()
+
+	if ok {
+		return
+	}
+
+	withLock(&dict.lock, func() {
+		if key, ok = dict.keys[term]; ok {
+			return
+		}
+
+		if key, ok = dict.lookupLegacy(term); ok {
+			return
+		}
+
+		key = dict.allocate()
+		dict.put(term, key)
+	})
+
+	return
+}
+
+// Returns the term of the given key.
+func (dict *Dictionary) Term(key uint32) (term string, ok bool) {
+	dict.lock.RLock()
+	defer dict.lock.RUnlock()
+
+	term, ok = dict.terms[key]
+	return
+}
+
+func (dict *Dictionary) Len() int {
+	dict.lock.RLock()
+	defer dict.lock.RUnlock()
+
+	return len(dict.keys)
+}
+
+// Returns all terms that share a key with another term, grouped by the key.
+func (dict *Dictionary) Collisions() map[uint32][]string {
+	dict.lock.RLock()
+	defer dict.lock.RUnlock()
+
+	result := make(map[uint32][]string, len(dict.collisions))
+	for key, terms := range dict.collisions {
+		result[key] = append([]string{dict.terms[key]}, terms...)
+	}
+
+	return result
+}
+
+// Marks keys of a store as legacy keys, that were created by hashing a term
+// with the legacy hasher. Keys that already have a term are ignored.
+func (dict *Dictionary) SetLegacyKeys(keys []uint32) {
+	withLock(&dict.lock, func() {
+		for _, key := range keys {
+			if _, ok := dict.terms[key]; !ok && dict.legacyHasher != nil {
+				dict.legacyKeys[key] = true
+			}
+		}
+	})
+}
+
+// Forgets all legacy keys that were not yet adopted by a term.
+func (dict *Dictionary) ForgetLegacyKeys() {
+	withLock(&dict.lock, func() {
+		dict.legacyKeys = make(map[uint32]bool)
+	})
+}
+
+// Returns the number of legacy keys that were not yet adopted by a term.
+func (dict *Dictionary) LegacyKeyCount() int {
+	dict.lock.RLock()
+	defer dict.lock.RUnlock()
+
+	return len(dict.legacyKeys)
+}
+
+// Calls the function for every term in the dictionary, sorted by key.
+func (dict *Dictionary) ForEach(fn func(term string, key uint32)) {
+	dict.lock.RLock()
+	keys := make([]uint32, 0, len(dict.terms))
+	for key := range dict.terms {
+		keys = append(keys, key)
+	}
+
+	terms := make([]string, len(keys))
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for idx, key := range keys {
+		terms[idx] = dict.terms[key]
+	}
+	dict.lock.RUnlock()
+
+	for idx, key := range keys {
+		fn(terms[idx], key)
+	}
+}
+
+// Adds a term with a fixed key, used to restore the dictionary.
+func (dict *Dictionary) add(term string, key uint32) {
+	withLock(&dict.lock, func() {
+		dict.put(term, key)
+	})
+}
+
+// Continues allocating keys after the given key.
+func (dict *Dictionary) allocateAfter(key uint32) {
+	withLock(&dict.lock, func() {
+		if key >= dict.nextKey {
+			dict.nextKey = key + 1
+		}
+	})
+}
+
+// Adds the term with a newly allocated key, even if a legacy key exists for the term.
+func (dict *Dictionary) addNew(term string) (key uint32) {
+	withLock(&dict.lock, func() {
+		key = dict.allocate()
+		dict.put(term, key)
+	})
+
+	return
+}
+
+// Must be called while holding the write lock.
+func (dict *Dictionary) lookupLegacy(term string) (uint32, bool) {
+	if len(dict.legacyKeys) == 0 {
+		return 0, false
+	}
+
+	key := dict.legacyHasher(term)
+	if dict.legacyKeys[key] {
+		delete(dict.legacyKeys, key)
+		dict.put(term, key)
+		return key, true
+	}
+
+	if other, ok := dict.terms[key]; ok && other != term && !containsTerm(dict.collisions[key], term) {
+		// the legacy key was already adopted by another term, so the data
+		// of both terms is merged in this key.
+		dict.collisions[key] = append(dict.collisions[key], term)
+	}
+
+	return 0, false
+}
+
+// Must be called while holding the write lock. Keys are allocated
+// sequentially, skipping keys that are already in use.
+func (dict *Dictionary) allocate() uint32 {
+	for {
+		key := dict.nextKey
+		dict.nextKey++
+
+		_, used := dict.terms[key]
+		if key != 0 && !used && !dict.legacyKeys[key] {
+			return key
+		}
+	}
+}
+
+// Must be called while holding the write lock.
+func (dict *Dictionary) put(term string, key uint32) {
+	dict.keys[term] = key
+	dict.terms[key] = term
+}
+
+func containsTerm(terms []string, term string) bool {
+	for _, candidate := range terms {
+		if candidate == term {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withLock(locker sync.Locker, fn func()) {
+	locker.Lock()
+	defer locker.Unlock()
+
+	fn()
+}
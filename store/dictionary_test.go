@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDictionaryAllocatesDistinctKeys(t *testing.T) {
+	dict := NewDictionary(testHasher)
+
+	// all terms have the same legacy hash, but there is no legacy store.
+	first, second := dict.KeyOf("foo"), dict.KeyOf("bar")
+	if first == second || first == 0 || second == 0 {
+		t.Errorf("Expected distinct keys greater than zero, got %d and %d", first, second)
+	}
+
+	if key, ok := dict.Lookup("foo"); !ok || key != first {
+		t.Error("Lookup did not return the allocated key")
+	}
+
+	if _, ok := dict.Lookup("baz"); ok {
+		t.Error("Lookup must not allocate a key")
+	}
+
+	if term, _ := dict.Term(second); term != "bar" {
+		t.Errorf("Expected term bar for key %d, got %s", second, term)
+	}
+}
+
+func TestDictionaryAdoptsLegacyKeys(t *testing.T) {
+	dict := NewDictionary(testHasher)
+	dict.SetLegacyKeys([]uint32{1, 3})
+
+	if key := dict.KeyOf("foo"); key != 3 {
+		t.Errorf("Expected legacy key 3 for foo, got %d", key)
+	}
+
+	// bar maps to the same legacy key, new keys must not use the legacy key 1
+	if key := dict.KeyOf("bar"); key == 1 || key == 3 {
+		t.Errorf("New key %d was taken from the legacy keys", key)
+	}
+
+	collisions := dict.Collisions()
+	if len(collisions[3]) != 2 || collisions[3][0] != "foo" || collisions[3][1] != "bar" {
+		t.Errorf("Expected a collision of foo and bar, got %v", collisions)
+	}
+
+	if key := dict.KeyOf("a"); key != 1 {
+		t.Errorf("Expected legacy key 1 for a, got %d", key)
+	}
+}
+
+func TestDictionaryLookupDoesNotAdoptLegacyKeys(t *testing.T) {
+	dict := NewDictionary(testHasher)
+	dict.SetLegacyKeys([]uint32{3})
+
+	// a query for bar is looked up before the indexed term foo.
+	if key, ok := dict.Lookup("bar"); !ok || key != 3 {
+		t.Errorf("Expected the legacy key 3 for bar, got %d", key)
+	}
+
+	if dict.LegacyKeyCount() != 1 || len(dict.Collisions()) != 0 {
+		t.Error("Lookup must not change the dictionary")
+	}
+
+	if key := dict.KeyOf("foo"); key != 3 {
+		t.Errorf("Expected legacy key 3 for foo, got %d", key)
+	}
+
+	if key, ok := dict.Lookup("foo"); !ok || key != 3 {
+		t.Errorf("Expected foo to be searchable using key 3, got %d", key)
+	}
+
+	if _, ok := dict.Lookup("bar"); ok {
+		t.Error("Expected bar to be unknown after foo adopted the legacy key")
+	}
+}
+
+func TestCheckpointRestoresDictionary(t *testing.T) {
+	dict := NewDictionary(testHasher)
+	source := NewIterStore(newGoByteStore())
+	source.Replace(dict.KeyOf("foo"), []int32{-10, -5})
+	source.Replace(dict.KeyOf("bar"), []int32{-7})
+
+	var buffer bytes.Buffer
+	if err := WriteCheckpoint(&buffer, StoreState{}, source, dict, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var state StoreState
+	restored := NewDictionary(testHasher)
+	target := NewIterStore(newGoByteStore())
+	if err := ReadCheckpoint(&buffer, &state, target, restored, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.LegacyKeyCount() != 0 {
+		t.Error("A current checkpoint must not have legacy keys")
+	}
+
+	for _, term := range []string{"foo", "bar"} {
+		key, ok := restored.Lookup(term)
+		if !ok {
+			t.Fatalf("Term %s is missing in the restored dictionary", term)
+		}
+
+		testIter(t, source.GetIterator(dict.KeyOf(term)), target.GetIterator(key))
+	}
+
+	// new keys are allocated after the loaded ones, without scanning them.
+	if restored.nextKey != dict.KeyOf("bar")+1 {
+		t.Errorf("Expected the next key after the loaded keys, got %d", restored.nextKey)
+	}
+
+	if key := restored.KeyOf("baz"); key == dict.KeyOf("foo") || key == dict.KeyOf("bar") {
+		t.Error("Restored dictionary allocated a key that is already in use")
+	}
+}
+
+func TestReadCheckpointMigratesLegacyKeys(t *testing.T) {
+	source := NewIterStore(newGoByteStore())
+	source.Replace(0, []int32{-10, -7, -5})
+	source.Replace(testHasher("foo"), []int32{-10, -5})
+
+	var buffer bytes.Buffer
+	if err := WriteCheckpoint(&buffer, StoreState{}, source, NewDictionary(testHasher), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint := bytes.Replace(buffer.Bytes(), []byte(`"Version":3`), []byte(`"Version":1`), 1)
+
+	var state StoreState
+	dict := NewDictionary(testHasher)
+	target := NewIterStore(newGoByteStore())
+	if err := ReadCheckpoint(bytes.NewReader(checkpoint), &state, target, dict, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.Cardinality(0) != 0 {
+		t.Error("Key zero must not be used after the migration")
+	}
+
+	allKey, ok := dict.Lookup(AllTerm)
+	if !ok || allKey == testHasher("foo") {
+		t.Fatalf("Expected a new key for %s, got %d", AllTerm, allKey)
+	}
+
+	testIter(t, iter(-10, -7, -5), target.GetIterator(allKey))
+
+	fooKey, _ := dict.Lookup("foo")
+	testIter(t, iter(-10, -5), target.GetIterator(fooKey))
+}
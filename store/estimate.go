@@ -0,0 +1,50 @@
+package store
+
+import "github.com/cznic/mathutil"
+
+// Estimates the number of items the iterator will produce. Leaf iterators
+// know their exact size, composite iterators are estimated assuming that their
+// children are independent subsets of a universe of the given size. If the size
+// of the universe is unknown, pass zero to get an upper bound instead.
+func EstimateSize(iter ItemIterator, universe int) int {
+	switch it := iter.(type) {
+	case *andIterator:
+		a, b := EstimateSize(it.first, universe), EstimateSize(it.second, universe)
+		if universe <= 0 {
+			return mathutil.Min(a, b)
+		}
+
+		return mathutil.Min(mathutil.Min(a, b), probabilityProduct(a, b, universe))
+
+	case *orIterator:
+		a, b := EstimateSize(it.first, universe), EstimateSize(it.second, universe)
+		if universe <= 0 {
+			return a + b
+		}
+
+		return mathutil.Max(mathutil.Max(a, b), a+b-probabilityProduct(a, b, universe))
+
+	case *diffIterator:
+		a, b := EstimateSize(it.first, universe), EstimateSize(it.second, universe)
+		if universe <= 0 {
+			return a
+		}
+
+		return mathutil.Max(0, a-probabilityProduct(a, b, universe))
+
+	case *limitIterator:
+		return mathutil.Min(it.remaining, EstimateSize(it.iter, universe))
+
+	case *negateIterator:
+		return EstimateSize(it.ItemIterator, universe)
+
+	default:
+		return iter.MaxSize()
+	}
+}
+
+// Expected size of the intersection of two independent random
+// subsets with sizes a and b of a universe with the given size.
+func probabilityProduct(a, b, universe int) int {
+	return int(float64(a) * float64(b) / float64(universe))
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCodecCountMatchesValues(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(1)), 5000, 0.1)
+	for _, codec := range append(candidateCodecs, int24CodecInstance) {
+		if count := codec.Count(codec.Encode(values)); count != len(values) {
+			t.Errorf("Codec %d returned count %d, expected %d", codec.Id(), count, len(values))
+		}
+
+		if size := codec.Decode(codec.Encode(values)).MaxSize(); size != len(values) {
+			t.Errorf("Iterator of codec %d returned size %d, expected %d", codec.Id(), size, len(values))
+		}
+	}
+}
+
+func TestIterStoreCardinality(t *testing.T) {
+	store := NewIterStore(newGoByteStore())
+	store.Replace(1, []int32{-5, -3, -1})
+
+	if store.Cardinality(1) != 3 {
+		t.Errorf("Expected cardinality 3, got %d", store.Cardinality(1))
+	}
+
+	if store.Cardinality(2) != 0 {
+		t.Error("Expected cardinality 0 for an unknown key")
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	a := iter(1, 2, 3, 4, 5, 6, 7, 8)
+	b := iter(1, 2, 3, 4)
+
+	if size := EstimateSize(NewAndIterator(a, b), 16); size != 2 {
+		t.Errorf("Expected estimate of 2 for AND, got %d", size)
+	}
+
+	if size := EstimateSize(NewOrIterator(a, b), 16); size != 10 {
+		t.Errorf("Expected estimate of 10 for OR, got %d", size)
+	}
+
+	if size := EstimateSize(NewDiffIterator(a, b), 16); size != 6 {
+		t.Errorf("Expected estimate of 6 for WITHOUT, got %d", size)
+	}
+
+	if size := EstimateSize(NewLimitIterator(3, NewOrIterator(a, b)), 0); size != 3 {
+		t.Errorf("Expected estimate of 3 for a limit, got %d", size)
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Maps item ids to the keys that contain the item. This is the inverse of
+// the store and is used to find the terms of an item.
+type ForwardIndex struct {
+	lock  sync.RWMutex
+	items map[int32][]uint32
+}
+
+func NewForwardIndex() *ForwardIndex {
+	return &ForwardIndex{items: make(map[int32][]uint32)}
+}
+
+// Adds all keys of the store to the index.
+func (index *ForwardIndex) AddStore(store IterStore) {
+	for _, key := range store.Keys() {
+		index.Add(key, IteratorToList(nil, store.GetIterator(key)))
+	}
+}
+
+// Records that the key contains the given items.
+func (index *ForwardIndex) Add(key uint32, items []int32) {
+	withLock(&index.lock, func() {
+		for _, item := range items {
+			keys := index.items[item]
+
+			idx := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+			if idx < len(keys) && keys[idx] == key {
+				continue
+			}
+
+			keys = append(keys, 0)
+			copy(keys[idx+1:], keys[idx:])
+			keys[idx] = key
+
+			index.items[item] = keys
+		}
+	})
+}
+
+// Records that the key does not contain the given items anymore.
+func (index *ForwardIndex) RemoveKey(key uint32, items []int32) {
+	withLock(&index.lock, func() {
+		for _, item := range items {
+			keys := index.items[item]
+
+			idx := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+			if idx == len(keys) || keys[idx] != key {
+				continue
+			}
+
+			if len(keys) == 1 {
+				delete(index.items, item)
+			} else {
+				index.items[item] = append(keys[:idx], keys[idx+1:]...)
+			}
+		}
+	})
+}
+
+// Removes the items with all of their keys.
+func (index *ForwardIndex) RemoveItems(items []int32) {
+	withLock(&index.lock, func() {
+		for _, item := range items {
+			delete(index.items, item)
+		}
+	})
+}
+
+// Returns a sorted copy of the keys of the item.
+func (index *ForwardIndex) Keys(item int32) []uint32 {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	return append([]uint32(nil), index.items[item]...)
+}
+
+func (index *ForwardIndex) ItemCount() int {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	return len(index.items)
+}
+
+func writeForwardIndex(writer io.Writer, index *ForwardIndex) error {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	if err := binary.Write(writer, byteOrder, uint32(len(index.items))); err != nil {
+		return err
+	}
+
+	for item, keys := range index.items {
+		if err := binary.Write(writer, byteOrder, item); err != nil {
+			return err
+		}
+
+		if err := binary.Write(writer, byteOrder, uint32(len(keys))); err != nil {
+			return err
+		}
+
+		if err := binary.Write(writer, byteOrder, keys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reads a forward index written by writeForwardIndex. If the index is nil,
+// the data is read and dropped.
+func readForwardIndex(reader io.Reader, index *ForwardIndex) error {
+	var itemCount uint32
+	if err := binary.Read(reader, byteOrder, &itemCount); err != nil {
+		return err
+	}
+
+	var keys []uint32
+	for idx := uint32(0); idx < itemCount; idx++ {
+		var item int32
+		var keyCount uint32
+		if err := binary.Read(reader, byteOrder, &item); err != nil {
+			return err
+		}
+
+		if err := binary.Read(reader, byteOrder, &keyCount); err != nil {
+			return err
+		}
+
+		keys = make([]uint32, keyCount)
+		if err := binary.Read(reader, byteOrder, keys); err != nil {
+			return err
+		}
+
+		if index != nil {
+			index.items[item] = keys
+		}
+	}
+
+	return nil
+}
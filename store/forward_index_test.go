@@ -0,0 +1,77 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func equalKeys(first, second []uint32) bool {
+	if len(first) != len(second) {
+		return false
+	}
+
+	for idx := range first {
+		if first[idx] != second[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestForwardIndexKeepsKeysSorted(t *testing.T) {
+	index := NewForwardIndex()
+	index.Add(5, []int32{-1, -2})
+	index.Add(2, []int32{-1})
+	index.Add(9, []int32{-1})
+	index.Add(5, []int32{-1})
+
+	if keys := index.Keys(-1); !equalKeys(keys, []uint32{2, 5, 9}) {
+		t.Errorf("Expected keys [2 5 9], got %v", keys)
+	}
+
+	index.RemoveKey(5, []int32{-1, -2})
+	if keys := index.Keys(-1); !equalKeys(keys, []uint32{2, 9}) {
+		t.Errorf("Expected keys [2 9], got %v", keys)
+	}
+
+	if index.ItemCount() != 1 {
+		t.Error("An item without keys must be removed")
+	}
+
+	index.RemoveItems([]int32{-1})
+	if len(index.Keys(-1)) != 0 || index.ItemCount() != 0 {
+		t.Error("Removed item still has keys")
+	}
+}
+
+func TestCheckpointWithForwardIndex(t *testing.T) {
+	dict := NewDictionary(testHasher)
+	source := NewIterStore(newGoByteStore())
+	source.Replace(dict.KeyOf("foo"), []int32{-10, -5})
+	source.Replace(dict.KeyOf("bar"), []int32{-5})
+
+	index := NewForwardIndex()
+	index.AddStore(source)
+
+	for _, written := range []*ForwardIndex{index, nil} {
+		var buffer bytes.Buffer
+		if err := WriteCheckpoint(&buffer, StoreState{}, source, dict, written); err != nil {
+			t.Fatal(err)
+		}
+
+		// with and without an index in the checkpoint, the result must be the same.
+		var state StoreState
+		restored := NewForwardIndex()
+		target := NewIterStore(newGoByteStore())
+		if err := ReadCheckpoint(&buffer, &state, target, NewDictionary(testHasher), restored); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, item := range []int32{-10, -5} {
+			if !equalKeys(index.Keys(item), restored.Keys(item)) {
+				t.Errorf("Expected keys %v for item %d, got %v", index.Keys(item), item, restored.Keys(item))
+			}
+		}
+	}
+}
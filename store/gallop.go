@@ -0,0 +1,40 @@
+package store
+
+import "sort"
+
+// Returns the index of the first value in values[pos:] that is not less than val,
+// or len(values) if there is no such value. The search first doubles its step size
+// to find a range containing the result and then does a binary search in this range.
+// This is fast for both, short and long jumps.
+func gallopSlice(values []int32, pos int, val int32) int {
+	return gallop(pos, len(values), func(idx int) bool {
+		return values[idx] >= val
+	})
+}
+
+// Same as gallopSlice, but for any sorted random access sequence of length n.
+// The function reached must return true if the value at the index is not less
+// than the searched value.
+func gallop(pos, n int, reached func(idx int) bool) int {
+	if pos >= n || reached(pos) {
+		return pos
+	}
+
+	// the value at lo is always less than the searched value
+	lo, step := pos, 1
+	hi := lo + step
+	for hi < n && !reached(hi) {
+		lo = hi
+		step *= 2
+		hi = lo + step
+	}
+
+	end := hi + 1
+	if end > n {
+		end = n
+	}
+
+	return lo + 1 + sort.Search(end-lo-1, func(i int) bool {
+		return reached(lo + 1 + i)
+	})
+}
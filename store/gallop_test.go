@@ -0,0 +1,54 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestGallopSlice(t *testing.T) {
+	values := []int32{1, 3, 5, 7, 9, 11, 13, 15, 17, 19}
+	for pos := 0; pos <= len(values); pos++ {
+		for val := int32(0); val <= 21; val++ {
+			expected := pos
+			for expected < len(values) && values[expected] < val {
+				expected++
+			}
+
+			if actual := gallopSlice(values, pos, val); actual != expected {
+				t.Errorf("gallopSlice(pos=%d, val=%d) returned %d, expected %d", pos, val, actual, expected)
+			}
+		}
+	}
+}
+
+func TestSkipUntilWithGallopingIterators(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(1)), 5000, 0.1)
+	targets := []int32{values[0] - 1, values[1], values[100] + 1, values[4000], values[len(values)-1] + 1}
+
+	iterators := map[string]func() FastItemIterator{
+		"slice": func() FastItemIterator { return NewSliceIterator(values).(FastItemIterator) },
+		"int24": func() FastItemIterator { return NewInt24Iterator(int24CodecInstance.Encode(values)).(FastItemIterator) },
+		"int32": func() FastItemIterator { return NewInt32Iterator(int32CodecInstance.Encode(values)).(FastItemIterator) },
+	}
+
+	for name, factory := range iterators {
+		it := factory()
+		expected := iter(values...)
+
+		for _, target := range targets {
+			it.SkipUntil(target)
+			for expected.HasMore() && expected.Peek() < target {
+				expected.Next()
+			}
+
+			if it.HasMore() != expected.HasMore() || it.HasMore() && it.Peek() != expected.Peek() {
+				t.Errorf("%s iterator did not skip to the expected value for %d", name, target)
+			}
+		}
+	}
+}
+
+func TestInt32IteratorKeepsFirstValue(t *testing.T) {
+	values := []int32{-10, -5, -1}
+	testIter(t, iter(values...), NewInt32Iterator(int32CodecInstance.Encode(values)))
+}
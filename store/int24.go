@@ -2,6 +2,9 @@ package store
 
 import "unsafe"
 
+const minInt24 = -1 << 23
+const maxInt24 = 1<<23 - 1
+
 func int24ToBytes(value int32) [3]byte {
 	var bytes [3]byte
 	bytes[0] = byte((value >> 16) & 0xff)
@@ -19,17 +22,17 @@ func bytesToInt24(bytes [3]byte) int32 {
 }
 
 type int24iterator struct {
-	pos      int
-	length   int
-	bytesPtr uintptr
-	next     int32
+	pos    int
+	length int
+	bytes  []byte
+	next   int32
 }
 
 func NewInt24Iterator(bytes []byte) ItemIterator {
 	it := &int24iterator{
-		length:   len(bytes),
-		pos:      -3,
-		bytesPtr: uintptr(unsafe.Pointer(&bytes[0])),
+		length: len(bytes),
+		pos:    -3,
+		bytes:  bytes,
 	}
 
 	it.Next()
@@ -49,7 +52,7 @@ func (it *int24iterator) Next() int32 {
 
 	it.pos += 3
 	if it.pos < it.length {
-		scratch := (*[3]byte)(unsafe.Pointer(it.bytesPtr + uintptr(it.pos)))
+		scratch := (*[3]byte)(unsafe.Pointer(&it.bytes[it.pos]))
 		it.next = bytesToInt24(*scratch)
 	}
 
@@ -57,9 +60,22 @@ func (it *int24iterator) Next() int32 {
 }
 
 func (it *int24iterator) SkipUntil(val int32) {
-	for it.HasMore() && it.Peek() < val {
-		it.Next()
+	if !it.HasMore() || it.next >= val {
+		return
 	}
+
+	idx := gallop(it.pos/3, it.length/3, func(idx int) bool {
+		return it.valueAt(idx) >= val
+	})
+
+	// position the iterator directly before the value and load it
+	it.pos = 3 * (idx - 1)
+	it.Next()
+}
+
+func (it *int24iterator) valueAt(idx int) int32 {
+	scratch := (*[3]byte)(unsafe.Pointer(&it.bytes[3*idx]))
+	return bytesToInt24(*scratch)
 }
 
 func (it *int24iterator) MaxSize() int {
@@ -71,8 +87,7 @@ func (it *int24iterator) ToSlice() []int32 {
 	slice := make([]int32, intCount)
 
 	for i := 0; i < intCount; i++ {
-		scratch := (*[3]byte)(unsafe.Pointer(it.bytesPtr + uintptr(3*i)))
-		slice[i] = bytesToInt24(*scratch)
+		slice[i] = it.valueAt(i)
 	}
 
 	return slice
@@ -1,30 +1,16 @@
 package store
 
-import (
-	"unsafe"
-)
-
 type int32byteIterator struct {
-	pos      int
-	intCount int
-	bytes    []byte
-	intView  *[1 << 24]int32
+	pos     int
+	intView []int32
 }
 
 func NewInt32Iterator(bytes []byte) ItemIterator {
-	intCount := len(bytes) / 4
-	it := &int32byteIterator{
-		bytes:    bytes,
-		intCount: intCount,
-		intView:  (*[1 << 24]int32)(unsafe.Pointer(&bytes[0])),
-	}
-
-	it.Next()
-	return it
+	return &int32byteIterator{intView: bytesAsInt32s(bytes)}
 }
 
 func (it *int32byteIterator) HasMore() bool {
-	return it.pos < it.intCount
+	return it.pos < len(it.intView)
 }
 
 func (it *int32byteIterator) Peek() int32 {
@@ -37,18 +23,16 @@ func (it *int32byteIterator) Next() int32 {
 }
 
 func (it *int32byteIterator) SkipUntil(val int32) {
-	for it.pos < it.intCount && it.intView[it.pos] < val {
-		it.pos += 1
-	}
+	it.pos = gallopSlice(it.intView, it.pos, val)
 }
 
 func (it *int32byteIterator) MaxSize() int {
-	return it.intCount
+	return len(it.intView)
 }
 
 func (it *int32byteIterator) ToSlice() []int32 {
-	slice := make([]int32, it.intCount)
-	copy(slice, it.intView[:it.intCount])
+	slice := make([]int32, len(it.intView))
+	copy(slice, it.intView)
 	return slice
 }
 
@@ -80,7 +64,5 @@ func (it *sliceIterator) MaxSize() int {
 }
 
 func (it *sliceIterator) SkipUntil(val int32) {
-	for it.position < len(it.values) && it.values[it.position] < val {
-		it.position += 1
-	}
+	it.position = gallopSlice(it.values, it.position, val)
 }
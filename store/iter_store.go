@@ -12,6 +12,21 @@ type IterStore interface {
 
 	Replace(key uint32, values []int32)
 	MemorySize() ByteSize
+
+	// Returns the codec that is used for the given key, or nil, if the key is unknown.
+	CodecOf(key uint32) SequenceCodec
+
+	// Returns the exact number of items stored for the key.
+	Cardinality(key uint32) int
+
+	// Removes the sorted items from the key. Returns true, if the key was changed.
+	RemoveItems(key uint32, items []int32) bool
+
+	// Removes the sorted items from all keys and returns the number of changed keys.
+	RemoveItemsFromAll(items []int32) int
+
+	encoded(key uint32) (SequenceCodec, []byte)
+	replaceEncoded(key uint32, codec SequenceCodec, bytes []byte)
 }
 
 type iterStore struct {
@@ -29,33 +44,49 @@ func NewIterStore(store ByteStore) IterStore {
 func (store *iterStore) PushInt(key uint32, value int32) {
 	bytes := store.Get(key)
 	if len(bytes) == 0 {
-		codec := int24CodecInstance
+		codec := int32CodecInstance
 		store.Push(key, codec.Id())
 		store.PushN(key, codec.Encode([]int32{value}))
 	} else {
 		codec := SequenceCodecById(bytes[0])
 		if !codec.CanAppend() {
-			panic(fmt.Errorf("Can not append to codec %s", codec))
+			panic(fmt.Errorf("Can not append to codec %d", codec.Id()))
 		}
 
 		store.PushN(key, codec.Encode([]int32{value}))
 	}
 }
 
+// Replaces the values of the key. The current codec of the key is kept, unless
+// its encoding got much bigger than the one of a different codec.
 func (store *iterStore) Replace(key uint32, values []int32) {
 	if len(values) > 0 {
-		store.Clear(key)
-
-		codec := OptimalCodec(len(values))
-		store.Push(key, codec.Id())
-		store.PushN(key, codec.Encode(values))
-		store.Compact(key)
+		codec, bytes := EncodeKeepingCodec(store.CodecOf(key), values, DefaultCodecPolicy)
+		store.replaceEncoded(key, codec, bytes)
 
 	} else {
 		store.Remove(key)
 	}
 }
 
+// Replaces the key with values that are already encoded using the codec.
+func (store *iterStore) replaceEncoded(key uint32, codec SequenceCodec, bytes []byte) {
+	store.Clear(key)
+	store.Push(key, codec.Id())
+	store.PushN(key, bytes)
+	store.Compact(key)
+}
+
+// Returns the codec and the encoded values of the key, or nil, if the key is unknown.
+func (store *iterStore) encoded(key uint32) (SequenceCodec, []byte) {
+	bytes := store.Get(key)
+	if len(bytes) == 0 {
+		return nil, nil
+	}
+
+	return SequenceCodecById(bytes[0]), bytes[1:]
+}
+
 func (store *iterStore) GetIterator(key uint32) ItemIterator {
 	bytes := store.Get(key)
 	if len(bytes) == 0 {
@@ -66,8 +97,96 @@ func (store *iterStore) GetIterator(key uint32) ItemIterator {
 	}
 }
 
+func (store *iterStore) CodecOf(key uint32) SequenceCodec {
+	bytes := store.Get(key)
+	if len(bytes) == 0 {
+		return nil
+	}
+
+	return SequenceCodecById(bytes[0])
+}
+
+func (store *iterStore) Cardinality(key uint32) int {
+	bytes := store.Get(key)
+	if len(bytes) == 0 {
+		return 0
+	}
+
+	return SequenceCodecById(bytes[0]).Count(bytes[1:])
+}
+
+func (store *iterStore) RemoveItems(key uint32, items []int32) bool {
+	if !ContainsAnyItem(store.GetIterator(key), items) {
+		return false
+	}
+
+	values := IteratorToList(nil, NewDiffIterator(store.GetIterator(key), NewSliceIterator(items)))
+	if len(values) == 0 {
+		store.Remove(key)
+		return true
+	}
+
+	// removing items does not require a different codec, so keep the current one.
+	codec := store.CodecOf(key)
+	store.replaceEncoded(key, codec, codec.Encode(values))
+	return true
+}
+
+func (store *iterStore) RemoveItemsFromAll(items []int32) int {
+	changedKeyCount := 0
+	for _, key := range store.Keys() {
+		if store.RemoveItems(key, items) {
+			changedKeyCount++
+		}
+	}
+
+	return changedKeyCount
+}
+
+// Checks if the iterator contains at least one of the sorted items. This skips
+// over the values of the iterator and does not need to decode all of them.
+func ContainsAnyItem(iter ItemIterator, items []int32) bool {
+	for _, item := range items {
+		IteratorSkipUntil(iter, item)
+		if !iter.HasMore() {
+			return false
+		}
+
+		if iter.Peek() == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Encodes the values of the key again, if the current policy selects a different
+// codec than the one in use. Returns true, if the key was re-encoded.
+func Reencode(store IterStore, key uint32) bool {
+	values := IteratorToList(nil, store.GetIterator(key))
+	if len(values) == 0 {
+		return false
+	}
+
+	codec, bytes := EncodeOptimal(values, DefaultCodecPolicy)
+	if codec == store.CodecOf(key) {
+		return false
+	}
+
+	store.replaceEncoded(key, codec, bytes)
+	return true
+}
+
+// Adds the values of all keys of the other store to the target store.
 func MergeIterStores(target, other IterStore) {
 	for _, key := range other.Keys() {
+		if target.CodecOf(key) == nil {
+			// keys that are not shared are copied without decoding them.
+			codec, bytes := other.encoded(key)
+			target.replaceEncoded(key, codec, bytes)
+			continue
+		}
+
 		values := IteratorToList(nil, NewOrIterator(target.GetIterator(key), other.GetIterator(key)))
 		target.Replace(key, values)
 	}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Creates a store with one key for each candidate codec, all containing the same values.
+func storeWithAllCodecs(values []int32) IterStore {
+	store := NewIterStore(newGoByteStore()).(*iterStore)
+	for idx, codec := range candidateCodecs {
+		key := uint32(idx + 1)
+		store.Push(key, codec.Id())
+		store.PushN(key, codec.Encode(values))
+	}
+
+	return store
+}
+
+func TestRemoveItems(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(5)), 5000, 0.3)
+	removed := []int32{values[0], values[17], values[17] + 1, values[len(values)-1]}
+
+	var expected []int32
+	for idx, value := range values {
+		if idx != 0 && idx != 17 && idx != len(values)-1 {
+			expected = append(expected, value)
+		}
+	}
+
+	store := storeWithAllCodecs(values)
+	for _, key := range store.Keys() {
+		if !store.RemoveItems(key, removed) {
+			t.Errorf("RemoveItems did not change key %d using codec %s", key, store.CodecOf(key))
+		}
+
+		if store.CodecOf(key) != candidateCodecs[key-1] {
+			t.Errorf("RemoveItems changed the codec of key %d to %d", key, store.CodecOf(key).Id())
+		}
+
+		testIter(t, iter(expected...), store.GetIterator(key))
+	}
+}
+
+func TestRemoveItemsNotContained(t *testing.T) {
+	store := storeWithAllCodecs([]int32{-10, -8, -6})
+	for _, key := range store.Keys() {
+		codec := store.CodecOf(key)
+		if store.RemoveItems(key, []int32{-11, -9, -7, -5}) {
+			t.Errorf("RemoveItems changed key %d without any matching item", key)
+		}
+
+		if store.CodecOf(key) != codec {
+			t.Errorf("Key %d was encoded again", key)
+		}
+	}
+}
+
+func TestRemoveItemsFromAll(t *testing.T) {
+	store := NewIterStore(newGoByteStore())
+	store.Replace(1, []int32{-10, -8, -6})
+	store.Replace(2, []int32{-9, -7})
+	store.Replace(3, []int32{-8})
+
+	if changed := store.RemoveItemsFromAll([]int32{-8, -5}); changed != 2 {
+		t.Errorf("Expected two changed keys, got %d", changed)
+	}
+
+	testIter(t, iter(-10, -6), store.GetIterator(1))
+	testIter(t, iter(-9, -7), store.GetIterator(2))
+
+	if store.KeyCount() != 2 {
+		t.Error("A key without items must be removed")
+	}
+}
+
+func TestMergeIterStores(t *testing.T) {
+	values := []int32{-10, -8, -6}
+	other := storeWithAllCodecs(values)
+
+	target := NewIterStore(newGoByteStore())
+	target.Replace(1, []int32{-9, -8})
+
+	MergeIterStores(target, other)
+
+	testIter(t, iter(-10, -9, -8, -6), target.GetIterator(1))
+
+	for _, key := range other.Keys() {
+		if key == 1 {
+			continue
+		}
+
+		if target.CodecOf(key) != other.CodecOf(key) {
+			t.Errorf("Key %d was encoded again while copying", key)
+		}
+
+		testIter(t, iter(values...), target.GetIterator(key))
+	}
+}
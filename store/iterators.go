@@ -78,10 +78,17 @@ type decompressingIterator struct {
 	next, previous int32
 	bytes          []byte
 	more           bool
+	count          int
 }
 
+// Decodes a list of varint encoded deltas. The exact item count
+// is not known, so MaxSize returns the number of bytes.
 func NewDecompressingIterator(bytes []byte) ItemIterator {
-	it := &decompressingIterator{bytes: bytes, more: true}
+	return newDecompressingIterator(bytes, len(bytes))
+}
+
+func newDecompressingIterator(bytes []byte, count int) ItemIterator {
+	it := &decompressingIterator{bytes: bytes, more: true, count: count}
 	it.advance()
 	return it
 }
@@ -105,7 +112,7 @@ func (it *decompressingIterator) Next() int32 {
 }
 
 func (it *decompressingIterator) MaxSize() int {
-	return len(it.bytes)
+	return it.count
 }
 
 func (it *decompressingIterator) SkipUntil(val int32) {
@@ -143,7 +150,7 @@ func fastVarint32(buf []byte) (int32, int) {
 	var s uint
 	for i, b := range buf {
 		if b < 0x80 {
-			if i > 4 || i == 4 && b > 1 {
+			if i > 4 || i == 4 && b > 0x0f {
 				return 0, -(i + 1) // overflow
 			}
 
@@ -229,6 +236,11 @@ func NewAndIterator(iterators ...ItemIterator) ItemIterator {
 		return iterators[0]
 
 	case len(iterators) == 2:
+		if a, b, ok := asFreshRoaringIterators(iterators[0], iterators[1]); ok {
+			// intersect both bitmaps directly
+			return newRoaringIterator(roaringAnd(a.containers, b.containers))
+		}
+
 		return &andIterator{iterators[0], iterators[1]}
 
 	default:
@@ -287,6 +299,11 @@ func NewOrIterator(iterators ...ItemIterator) ItemIterator {
 		return iterators[0]
 
 	case len(iterators) == 2:
+		if a, b, ok := asFreshRoaringIterators(iterators[0], iterators[1]); ok {
+			// merge both bitmaps directly
+			return newRoaringIterator(roaringOr(a.containers, b.containers))
+		}
+
 		return &orIterator{iterators[0], iterators[1]}
 
 	default:
@@ -411,7 +428,7 @@ func (it *diffIterator) Next() int32 {
 }
 
 func (it *diffIterator) MaxSize() int {
-	return mathutil.Max(it.first.MaxSize(), it.second.MaxSize())
+	return it.first.MaxSize()
 }
 
 func (it *diffIterator) SkipUntil(val int32) {
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Hides the SkipUntil method of the iterator, so that
+// skipping falls back to calling Next() repeatedly.
+type linearIterator struct {
+	ItemIterator
+}
+
+var benchmarkLarge = randomItems(rand.New(rand.NewSource(1)), 1000000, 0.5)
+var benchmarkSmall = randomItems(rand.New(rand.NewSource(2)), 100, 0.00005)
+
+func benchmarkAnd(b *testing.B, large func() ItemIterator) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IteratorToList(nil, NewAndIterator(large(), NewSliceIterator(benchmarkSmall)))
+	}
+}
+
+func BenchmarkAndInt32Linear(b *testing.B) {
+	bytes := int32CodecInstance.Encode(benchmarkLarge)
+	benchmarkAnd(b, func() ItemIterator {
+		return linearIterator{NewInt32Iterator(bytes)}
+	})
+}
+
+func BenchmarkAndInt32Galloping(b *testing.B) {
+	bytes := int32CodecInstance.Encode(benchmarkLarge)
+	benchmarkAnd(b, func() ItemIterator {
+		return NewInt32Iterator(bytes)
+	})
+}
+
+func BenchmarkAndInt24Linear(b *testing.B) {
+	bytes := int24CodecInstance.Encode(benchmarkLarge)
+	benchmarkAnd(b, func() ItemIterator {
+		return linearIterator{NewInt24Iterator(bytes)}
+	})
+}
+
+func BenchmarkAndInt24Galloping(b *testing.B) {
+	bytes := int24CodecInstance.Encode(benchmarkLarge)
+	benchmarkAnd(b, func() ItemIterator {
+		return NewInt24Iterator(bytes)
+	})
+}
+
+func BenchmarkAndSliceLinear(b *testing.B) {
+	benchmarkAnd(b, func() ItemIterator {
+		return linearIterator{NewSliceIterator(benchmarkLarge)}
+	})
+}
+
+func BenchmarkAndSliceGalloping(b *testing.B) {
+	benchmarkAnd(b, func() ItemIterator {
+		return NewSliceIterator(benchmarkLarge)
+	})
+}
+
+var benchmarkMedium = randomItems(rand.New(rand.NewSource(3)), 50000, 0.02)
+
+func benchmarkToList(b *testing.B, codec SequenceCodec) {
+	bytes := codec.Encode(benchmarkMedium)
+	b.SetBytes(int64(4 * len(benchmarkMedium)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IteratorToList(nil, codec.Decode(bytes))
+	}
+}
+
+func BenchmarkToListVarint(b *testing.B) {
+	benchmarkToList(b, varintCodecInstance)
+}
+
+func BenchmarkToListBlockVarint(b *testing.B) {
+	benchmarkToList(b, blockVarintCodecInstance)
+}
+
+func BenchmarkToListPFor(b *testing.B) {
+	benchmarkToList(b, pforCodecInstance)
+}
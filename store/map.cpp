@@ -1,3 +1,5 @@
+// +build !purego
+
 #include <cstddef>
 
 #include <vector>
@@ -45,8 +47,8 @@ template<class K, class V>
 void small_map<K, V>::erase(const K& key) {
     auto it = std::lower_bound(keys.begin(), keys.end(), key);
     if(it != keys.end() && *it == key) {
-        keys.erase(it);
         values.erase(values.begin() + std::distance(keys.begin(), it));
+        keys.erase(it);
     }
 }
 
@@ -0,0 +1,268 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Number of values in one block of the pfor codec.
+const pforBlockSize = 128
+
+// Encodes the values using patched frame of reference bit packing. Values are
+// split into blocks of pforBlockSize values and use the same block index as
+// the block varint codec. Each block stores the gaps between its values:
+// the bit width (byte) and the number of exceptions (byte), the lower bits of
+// all gaps packed with the bit width and then one entry for each gap that does
+// not fit into the bit width: its index (byte) and its upper bits (uvarint).
+func pforEncode(values []int32) []byte {
+	blockCount := (len(values) + pforBlockSize - 1) / pforBlockSize
+
+	scratch := make([]byte, binary.MaxVarintLen32+varintBlockHeaderSize*blockCount+len(values)*(4+binary.MaxVarintLen32)+2*blockCount)
+	written := binary.PutUvarint(scratch, uint64(len(values)))
+
+	header := blockIndex(scratch[written:])
+	data := header[varintBlockHeaderSize*blockCount:]
+
+	var gaps [pforBlockSize]uint32
+
+	offset := 0
+	for block := 0; block < blockCount; block++ {
+		blockValues := values[block*pforBlockSize:]
+		if len(blockValues) > pforBlockSize {
+			blockValues = blockValues[:pforBlockSize]
+		}
+
+		header.put(block, blockValues[0], offset)
+
+		gapCount := len(blockValues) - 1
+		for idx := 0; idx < gapCount; idx++ {
+			gaps[idx] = uint32(blockValues[idx+1] - blockValues[idx])
+		}
+
+		offset += pforEncodeBlock(data[offset:], gaps[:gapCount])
+	}
+
+	return scratch[:written+varintBlockHeaderSize*blockCount+offset]
+}
+
+func pforEncodeBlock(target []byte, gaps []uint32) int {
+	width := pforBestWidth(gaps)
+
+	exceptionCount := 0
+	for _, gap := range gaps {
+		if gap>>width != 0 {
+			exceptionCount++
+		}
+	}
+
+	target[0] = byte(width)
+	target[1] = byte(exceptionCount)
+	written := 2
+
+	// pack the lower bits of all gaps, least significant bit first.
+	var acc uint64
+	var accBits uint
+	mask := uint64(1)<<width - 1
+	for _, gap := range gaps {
+		acc |= (uint64(gap) & mask) << accBits
+		accBits += width
+
+		for accBits >= 8 {
+			target[written] = byte(acc)
+			written++
+			acc >>= 8
+			accBits -= 8
+		}
+	}
+
+	if accBits > 0 {
+		target[written] = byte(acc)
+		written++
+	}
+
+	// now patch in the exceptions
+	for idx, gap := range gaps {
+		if gap>>width != 0 {
+			target[written] = byte(idx)
+			written++
+			written += binary.PutUvarint(target[written:], uint64(gap>>width))
+		}
+	}
+
+	return written
+}
+
+// Finds the bit width that produces the smallest block.
+func pforBestWidth(gaps []uint32) uint {
+	bestWidth, bestSize := uint(32), -1
+	for width := uint(0); width <= 32; width++ {
+		size := (len(gaps)*int(width) + 7) / 8
+		for _, gap := range gaps {
+			if high := uint64(gap) >> width; high != 0 {
+				size += 1 + uvarintSize(high)
+			}
+		}
+
+		if bestSize < 0 || size < bestSize {
+			bestWidth, bestSize = width, size
+		}
+	}
+
+	return bestWidth
+}
+
+func uvarintSize(value uint64) int {
+	size := 1
+	for value >= 0x80 {
+		value >>= 7
+		size++
+	}
+
+	return size
+}
+
+// Decodes one block with the given first value and number of values into target.
+func pforDecodeBlock(target []int32, data []byte, first int32) {
+	width := uint(data[0])
+	exceptionCount := int(data[1])
+	pos := 2
+
+	gaps := target[1:]
+
+	var acc uint64
+	var accBits uint
+	mask := uint64(1)<<width - 1
+	for idx := range gaps {
+		for accBits < width {
+			acc |= uint64(data[pos]) << accBits
+			pos++
+			accBits += 8
+		}
+
+		gaps[idx] = int32(acc & mask)
+		acc >>= width
+		accBits -= width
+	}
+
+	for e := 0; e < exceptionCount; e++ {
+		idx := int(data[pos])
+		high, n := binary.Uvarint(data[pos+1:])
+		if n <= 0 {
+			panic(errors.New("Could not decode pfor exception."))
+		}
+
+		pos += 1 + n
+		gaps[idx] |= int32(high << width)
+	}
+
+	target[0] = first
+	for idx := 1; idx < len(target); idx++ {
+		target[idx] += target[idx-1]
+	}
+}
+
+type pforIterator struct {
+	count      int
+	blockCount int
+	header     blockIndex
+	data       []byte
+
+	block  int
+	pos    int
+	values []int32
+	buffer [pforBlockSize]int32
+
+	more bool
+}
+
+func NewPForIterator(bytes []byte) ItemIterator {
+	count, blockCount, header, data := readBlockIndex(bytes, pforBlockSize)
+
+	it := &pforIterator{
+		count:      count,
+		blockCount: blockCount,
+		header:     header,
+		data:       data,
+	}
+
+	if blockCount > 0 {
+		it.loadBlock(0)
+	}
+
+	return it
+}
+
+func (it *pforIterator) blockLength(block int) int {
+	if block == it.blockCount-1 {
+		return it.count - block*pforBlockSize
+	}
+
+	return pforBlockSize
+}
+
+func (it *pforIterator) loadBlock(block int) {
+	it.block = block
+	it.pos = 0
+	it.more = true
+
+	it.values = it.buffer[:it.blockLength(block)]
+	pforDecodeBlock(it.values, it.data[it.header.offset(block):], it.header.first(block))
+}
+
+func (it *pforIterator) HasMore() bool {
+	return it.more
+}
+
+func (it *pforIterator) Peek() int32 {
+	return it.values[it.pos]
+}
+
+func (it *pforIterator) Next() int32 {
+	value := it.values[it.pos]
+	it.advance()
+	return value
+}
+
+func (it *pforIterator) advance() {
+	it.pos++
+	if it.pos >= len(it.values) {
+		if it.block+1 < it.blockCount {
+			it.loadBlock(it.block + 1)
+		} else {
+			it.more = false
+		}
+	}
+}
+
+func (it *pforIterator) SkipUntil(val int32) {
+	if !it.more || it.values[it.pos] >= val {
+		return
+	}
+
+	// jump directly into the last block that starts with a value not greater than val.
+	if block := it.header.search(it.block+1, it.blockCount, val); block > it.block {
+		it.loadBlock(block)
+	}
+
+	it.pos = gallopSlice(it.values, it.pos, val)
+	if it.pos >= len(it.values) {
+		// all values of this block are smaller, the next one starts with a greater value.
+		it.pos = len(it.values) - 1
+		it.advance()
+	}
+}
+
+func (it *pforIterator) MaxSize() int {
+	return it.count
+}
+
+func (it *pforIterator) ToSlice() []int32 {
+	slice := make([]int32, it.count)
+	for block := 0; block < it.blockCount; block++ {
+		start := block * pforBlockSize
+		target := slice[start : start+it.blockLength(block)]
+		pforDecodeBlock(target, it.data[it.header.offset(block):], it.header.first(block))
+	}
+
+	return slice
+}
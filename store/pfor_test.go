@@ -0,0 +1,63 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func pforIter(values []int32) ItemIterator {
+	return NewPForIterator(pforEncode(values))
+}
+
+func TestPForRoundtrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, count := range []int{1, 2, pforBlockSize - 1, pforBlockSize, pforBlockSize + 1, 20000} {
+		for _, density := range []float64{0.00001, 0.01, 0.9} {
+			values := randomItems(rng, count, density)
+			testIter(t, iter(values...), pforIter(values))
+			testIter(t, iter(values...), iter(IteratorToList(nil, pforIter(values))...))
+		}
+	}
+}
+
+func TestPForRoundtripWithExceptions(t *testing.T) {
+	var values []int32
+	for idx := int32(0); idx < 1000; idx++ {
+		values = append(values, -1<<31+idx*3)
+	}
+
+	// some huge gaps in between
+	values = append(values, -100000, -5, 1<<31-1)
+	testIter(t, iter(values...), pforIter(values))
+}
+
+func TestPForSkipUntil(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(2)), 10000, 0.05)
+
+	it := pforIter(values).(FastItemIterator)
+	targets := []int32{values[0], values[3], values[pforBlockSize-1] + 1, values[pforBlockSize],
+		values[pforBlockSize] + 1, values[5000], values[len(values)-1]}
+
+	for _, target := range targets {
+		it.SkipUntil(target)
+
+		expected := iter(values...)
+		IteratorSkipUntil(expected, target)
+
+		if it.Peek() != expected.Peek() {
+			t.Errorf("SkipUntil(%d) moved to %d, expected was %d", target, it.Peek(), expected.Peek())
+		}
+	}
+
+	it.SkipUntil(values[len(values)-1] + 1)
+	if it.HasMore() {
+		t.Error("SkipUntil behind the last value must exhaust the iterator")
+	}
+}
+
+func TestPForIsSmallerThanVarint(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(3)), 20000, 0.02)
+	if pfor, varint := len(pforEncode(values)), len(varintCodecInstance.Encode(values)); pfor >= varint {
+		t.Errorf("Expected pfor (%d bytes) to be smaller than varint (%d bytes)", pfor, varint)
+	}
+}
@@ -0,0 +1,481 @@
+package store
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Containers with more items than this are stored as a bitmap,
+// smaller ones as a sorted array of the lower 16 bits.
+const roaringArrayMaxSize = 4096
+
+const roaringBitmapWords = 1 << 16 / 64
+
+const roaringHeaderSize = 8
+
+// container count and total item count
+const roaringPreambleSize = 8
+
+// One container holds all values that share the same upper 16 bits.
+type roaringContainer struct {
+	key  uint16
+	card int
+
+	// array containers hold 2*card bytes, bitmap containers roaringBitmapWords*8 bytes.
+	data []byte
+}
+
+func (c *roaringContainer) isBitmap() bool {
+	return c.card > roaringArrayMaxSize
+}
+
+func (c *roaringContainer) arrayValue(idx int) uint16 {
+	return binary.LittleEndian.Uint16(c.data[2*idx:])
+}
+
+func (c *roaringContainer) word(idx int) uint64 {
+	return binary.LittleEndian.Uint64(c.data[8*idx:])
+}
+
+// Returns the first set bit that is greater or equal to pos or -1.
+func (c *roaringContainer) nextSetBit(pos int) int {
+	idx := pos / 64
+	if idx >= roaringBitmapWords {
+		return -1
+	}
+
+	word := c.word(idx) >> uint(pos%64)
+	if word != 0 {
+		return pos + bits.TrailingZeros64(word)
+	}
+
+	for idx++; idx < roaringBitmapWords; idx++ {
+		if word := c.word(idx); word != 0 {
+			return 64*idx + bits.TrailingZeros64(word)
+		}
+	}
+
+	return -1
+}
+
+func (c *roaringContainer) contains(low uint16) bool {
+	if c.isBitmap() {
+		return c.word(int(low/64))&(1<<(low%64)) != 0
+	}
+
+	idx := sort.Search(c.card, func(i int) bool { return c.arrayValue(i) >= low })
+	return idx < c.card && c.arrayValue(idx) == low
+}
+
+// Appends all values of the container to the given slice.
+func (c *roaringContainer) appendLows(lows []uint16) []uint16 {
+	if c.isBitmap() {
+		for idx := 0; idx < roaringBitmapWords; idx++ {
+			word := c.word(idx)
+			for word != 0 {
+				lows = append(lows, uint16(64*idx+bits.TrailingZeros64(word)))
+				word &= word - 1
+			}
+		}
+	} else {
+		for idx := 0; idx < c.card; idx++ {
+			lows = append(lows, c.arrayValue(idx))
+		}
+	}
+
+	return lows
+}
+
+func (c *roaringContainer) words() []uint64 {
+	words := make([]uint64, roaringBitmapWords)
+	if c.isBitmap() {
+		for idx := range words {
+			words[idx] = c.word(idx)
+		}
+	} else {
+		for idx := 0; idx < c.card; idx++ {
+			low := c.arrayValue(idx)
+			words[low/64] |= 1 << (low % 64)
+		}
+	}
+
+	return words
+}
+
+func newArrayContainer(key uint16, lows []uint16) roaringContainer {
+	if len(lows) > roaringArrayMaxSize {
+		words := make([]uint64, roaringBitmapWords)
+		for _, low := range lows {
+			words[low/64] |= 1 << (low % 64)
+		}
+
+		return newBitmapContainer(key, words)
+	}
+
+	data := make([]byte, 2*len(lows))
+	for idx, low := range lows {
+		binary.LittleEndian.PutUint16(data[2*idx:], low)
+	}
+
+	return roaringContainer{key: key, card: len(lows), data: data}
+}
+
+func newBitmapContainer(key uint16, words []uint64) roaringContainer {
+	card := 0
+	for _, word := range words {
+		card += bits.OnesCount64(word)
+	}
+
+	if card <= roaringArrayMaxSize {
+		lows := make([]uint16, 0, card)
+		for idx, word := range words {
+			for word != 0 {
+				lows = append(lows, uint16(64*idx+bits.TrailingZeros64(word)))
+				word &= word - 1
+			}
+		}
+
+		return newArrayContainer(key, lows)
+	}
+
+	data := make([]byte, 8*roaringBitmapWords)
+	for idx, word := range words {
+		binary.LittleEndian.PutUint64(data[8*idx:], word)
+	}
+
+	return roaringContainer{key: key, card: card, data: data}
+}
+
+// Maps the signed value to an unsigned value with the same ordering.
+func roaringSplit(value int32) (uint16, uint16) {
+	u := uint32(value) ^ 0x80000000
+	return uint16(u >> 16), uint16(u)
+}
+
+func roaringJoin(key, low uint16) int32 {
+	return int32((uint32(key)<<16 | uint32(low)) ^ 0x80000000)
+}
+
+func roaringEncode(values []int32) []byte {
+	var containers []roaringContainer
+
+	var lows []uint16
+	for idx, value := range values {
+		key, low := roaringSplit(value)
+		lows = append(lows, low)
+
+		if idx+1 == len(values) {
+			containers = append(containers, newArrayContainer(key, lows))
+		} else if nextKey, _ := roaringSplit(values[idx+1]); nextKey != key {
+			containers = append(containers, newArrayContainer(key, lows))
+			lows = lows[:0]
+		}
+	}
+
+	return roaringSerialize(containers)
+}
+
+// Layout: container count (uint32), item count (uint32), then one header per container
+// with key (uint16), card-1 (uint16) and data offset (uint32) followed by the data
+// of all containers. All numbers are stored in little endian.
+func roaringSerialize(containers []roaringContainer) []byte {
+	size := roaringPreambleSize + roaringHeaderSize*len(containers)
+
+	itemCount := 0
+	for _, c := range containers {
+		size += len(c.data)
+		itemCount += c.card
+	}
+
+	bytes := make([]byte, size)
+	binary.LittleEndian.PutUint32(bytes, uint32(len(containers)))
+	binary.LittleEndian.PutUint32(bytes[4:], uint32(itemCount))
+
+	offset := roaringPreambleSize + roaringHeaderSize*len(containers)
+	for idx, c := range containers {
+		header := bytes[roaringPreambleSize+roaringHeaderSize*idx:]
+		binary.LittleEndian.PutUint16(header[0:], c.key)
+		binary.LittleEndian.PutUint16(header[2:], uint16(c.card-1))
+		binary.LittleEndian.PutUint32(header[4:], uint32(offset))
+
+		offset += copy(bytes[offset:], c.data)
+	}
+
+	return bytes
+}
+
+func roaringCount(bytes []byte) int {
+	return int(binary.LittleEndian.Uint32(bytes[4:]))
+}
+
+func roaringDeserialize(bytes []byte) []roaringContainer {
+	count := int(binary.LittleEndian.Uint32(bytes))
+
+	containers := make([]roaringContainer, count)
+	for idx := range containers {
+		header := bytes[roaringPreambleSize+roaringHeaderSize*idx:]
+
+		c := &containers[idx]
+		c.key = binary.LittleEndian.Uint16(header[0:])
+		c.card = int(binary.LittleEndian.Uint16(header[2:])) + 1
+
+		offset := int(binary.LittleEndian.Uint32(header[4:]))
+		if c.isBitmap() {
+			c.data = bytes[offset : offset+8*roaringBitmapWords]
+		} else {
+			c.data = bytes[offset : offset+2*c.card]
+		}
+	}
+
+	return containers
+}
+
+func roaringAnd(first, second []roaringContainer) []roaringContainer {
+	var result []roaringContainer
+
+	for i, j := 0, 0; i < len(first) && j < len(second); {
+		a, b := &first[i], &second[j]
+		switch {
+		case a.key < b.key:
+			i++
+
+		case a.key > b.key:
+			j++
+
+		default:
+			var c roaringContainer
+			switch {
+			case a.isBitmap() && b.isBitmap():
+				words := make([]uint64, roaringBitmapWords)
+				for idx := range words {
+					words[idx] = a.word(idx) & b.word(idx)
+				}
+
+				c = newBitmapContainer(a.key, words)
+
+			case a.isBitmap():
+				c = newArrayContainer(a.key, filterLows(b, a))
+
+			default:
+				c = newArrayContainer(a.key, filterLows(a, b))
+			}
+
+			if c.card > 0 {
+				result = append(result, c)
+			}
+
+			i++
+			j++
+		}
+	}
+
+	return result
+}
+
+// Returns all values of the array container that are also in the other container.
+func filterLows(array, other *roaringContainer) []uint16 {
+	var lows []uint16
+	for idx := 0; idx < array.card; idx++ {
+		if low := array.arrayValue(idx); other.contains(low) {
+			lows = append(lows, low)
+		}
+	}
+
+	return lows
+}
+
+func roaringOr(first, second []roaringContainer) []roaringContainer {
+	result := make([]roaringContainer, 0, len(first)+len(second))
+
+	i, j := 0, 0
+	for i < len(first) && j < len(second) {
+		a, b := &first[i], &second[j]
+		switch {
+		case a.key < b.key:
+			result = append(result, *a)
+			i++
+
+		case a.key > b.key:
+			result = append(result, *b)
+			j++
+
+		default:
+			if a.isBitmap() || b.isBitmap() {
+				words, other := a.words(), b.words()
+				for idx := range words {
+					words[idx] |= other[idx]
+				}
+
+				result = append(result, newBitmapContainer(a.key, words))
+			} else {
+				lows := mergeLows(a.appendLows(nil), b.appendLows(nil))
+				result = append(result, newArrayContainer(a.key, lows))
+			}
+
+			i++
+			j++
+		}
+	}
+
+	result = append(result, first[i:]...)
+	result = append(result, second[j:]...)
+	return result
+}
+
+func mergeLows(a, b []uint16) []uint16 {
+	result := make([]uint16, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			result, a = append(result, a[0]), a[1:]
+		case a[0] > b[0]:
+			result, b = append(result, b[0]), b[1:]
+		default:
+			result, a, b = append(result, a[0]), a[1:], b[1:]
+		}
+	}
+
+	result = append(result, a...)
+	return append(result, b...)
+}
+
+type roaringIterator struct {
+	containers []roaringContainer
+
+	// index of the current container and position inside of the container.
+	// This is an index for array containers and a bit position for bitmaps.
+	container int
+	pos       int
+
+	next  int32
+	more  bool
+	size  int
+	moved bool
+}
+
+func NewRoaringIterator(bytes []byte) ItemIterator {
+	return newRoaringIterator(roaringDeserialize(bytes))
+}
+
+func newRoaringIterator(containers []roaringContainer) *roaringIterator {
+	it := &roaringIterator{containers: containers}
+	for _, c := range containers {
+		it.size += c.card
+	}
+
+	it.settle()
+	return it
+}
+
+// Moves the position forward to the next existing value,
+// starting at the current position.
+func (it *roaringIterator) settle() {
+	for it.container < len(it.containers) {
+		c := &it.containers[it.container]
+		if c.isBitmap() {
+			if pos := c.nextSetBit(it.pos); pos >= 0 {
+				it.pos = pos
+				it.next = roaringJoin(c.key, uint16(pos))
+				it.more = true
+				return
+			}
+		} else if it.pos < c.card {
+			it.next = roaringJoin(c.key, c.arrayValue(it.pos))
+			it.more = true
+			return
+		}
+
+		it.container++
+		it.pos = 0
+	}
+
+	it.more = false
+}
+
+func (it *roaringIterator) HasMore() bool {
+	return it.more
+}
+
+func (it *roaringIterator) Peek() int32 {
+	return it.next
+}
+
+func (it *roaringIterator) Next() int32 {
+	value := it.next
+
+	it.moved = true
+	it.pos++
+	it.settle()
+
+	return value
+}
+
+func (it *roaringIterator) SkipUntil(val int32) {
+	if !it.more || it.next >= val {
+		return
+	}
+
+	it.moved = true
+	key, low := roaringSplit(val)
+
+	// jump over all containers that can not contain the value
+	if it.containers[it.container].key < key {
+		rest := it.containers[it.container+1:]
+		it.container += 1 + sort.Search(len(rest), func(i int) bool { return rest[i].key >= key })
+		it.pos = 0
+
+		if it.container >= len(it.containers) || it.containers[it.container].key > key {
+			it.settle()
+			return
+		}
+	}
+
+	c := &it.containers[it.container]
+	if c.isBitmap() {
+		if int(low) > it.pos {
+			it.pos = int(low)
+		}
+	} else {
+		rest := c.card - it.pos
+		it.pos += sort.Search(rest, func(i int) bool { return c.arrayValue(it.pos+i) >= low })
+	}
+
+	it.settle()
+}
+
+func (it *roaringIterator) MaxSize() int {
+	return it.size
+}
+
+func (it *roaringIterator) ToSlice() []int32 {
+	slice := make([]int32, 0, it.size)
+
+	var lows []uint16
+	for idx := range it.containers {
+		c := &it.containers[idx]
+
+		lows = c.appendLows(lows[:0])
+		for _, low := range lows {
+			slice = append(slice, roaringJoin(c.key, low))
+		}
+	}
+
+	return slice
+}
+
+// Returns both iterators as roaring iterators, if both of them
+// are untouched roaring iterators.
+func asFreshRoaringIterators(first, second ItemIterator) (*roaringIterator, *roaringIterator, bool) {
+	a, ok := first.(*roaringIterator)
+	if !ok || a.moved {
+		return nil, nil, false
+	}
+
+	b, ok := second.(*roaringIterator)
+	if !ok || b.moved {
+		return nil, nil, false
+	}
+
+	return a, b, true
+}
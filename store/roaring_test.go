@@ -0,0 +1,80 @@
+package store
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cznic/sortutil"
+)
+
+// Generates a sorted list of unique negative item ids with the given density.
+func randomItems(rng *rand.Rand, count int, density float64) []int32 {
+	span := int(float64(count) / density)
+
+	values := make([]int32, count)
+	for idx := range values {
+		values[idx] = -int32(rng.Intn(span)) - 1
+	}
+
+	n := sortutil.Dedupe(sortutil.Int32Slice(values))
+	return values[:n]
+}
+
+func roaringIter(values []int32) ItemIterator {
+	return NewRoaringIterator(roaringEncode(values))
+}
+
+func TestRoaringRoundtrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, density := range []float64{0.01, 0.2, 0.9} {
+		values := randomItems(rng, 50000, density)
+		testIter(t, iter(values...), roaringIter(values))
+		testIter(t, iter(values...), iter(IteratorToList(nil, roaringIter(values))...))
+	}
+}
+
+func TestRoaringRoundtripSignBoundary(t *testing.T) {
+	values := []int32{-1 << 31, -70000, -65536, -1, 0, 1, 65536, 1<<31 - 1}
+	testIter(t, iter(values...), roaringIter(values))
+}
+
+func TestRoaringSkipUntil(t *testing.T) {
+	values := randomItems(rand.New(rand.NewSource(2)), 100000, 0.5)
+
+	it := roaringIter(values).(FastItemIterator)
+	for _, target := range []int32{values[10], values[10] + 1, values[5000], values[60000] - 1, values[len(values)-1]} {
+		it.SkipUntil(target)
+
+		expected := iter(values...)
+		IteratorSkipUntil(expected, target)
+
+		if it.Peek() != expected.Peek() {
+			t.Errorf("SkipUntil(%d) moved to %d, expected was %d", target, it.Peek(), expected.Peek())
+		}
+	}
+
+	it.SkipUntil(values[len(values)-1] + 1)
+	if it.HasMore() {
+		t.Error("SkipUntil behind the last value must exhaust the iterator")
+	}
+}
+
+func TestRoaringAndFastPath(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	first := randomItems(rng, 80000, 0.5)
+	second := randomItems(rng, 20000, 0.1)
+
+	testIter(t,
+		&andIterator{iter(first...), iter(second...)},
+		NewAndIterator(roaringIter(first), roaringIter(second)))
+}
+
+func TestRoaringOrFastPath(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	first := randomItems(rng, 80000, 0.5)
+	second := randomItems(rng, 20000, 0.1)
+
+	testIter(t,
+		&orIterator{iter(first...), iter(second...)},
+		NewOrIterator(roaringIter(first), roaringIter(second)))
+}
@@ -1,3 +1,5 @@
+// +build !purego
+
 #include "sequence.hpp"
 
 #include <algorithm>
@@ -47,6 +49,7 @@ sequence& sequence::operator=(sequence&& other) {
 
     other.inline_data = true;
     other.d.direct[0] = 0;
+    return *this;
 }
 
 sequence::~sequence() {
@@ -86,7 +89,8 @@ void sequence::push(uint8_t value) {
     } else {
         const auto need_to_grow = d.heap.length == d.heap.capacity;
         if(need_to_grow) {
-            auto new_capacity = 8 + 13 * d.heap.capacity / 10;
+            // grow in 64 bit to not overflow for big sequences
+            auto new_capacity = (uint32_t) std::min<uint64_t>(8 + 13ull * d.heap.capacity / 10, UINT32_MAX);
             auto new_data = allocate_and_copy(new_capacity, view());
 
             delete[] d.heap.ptr;
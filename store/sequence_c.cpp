@@ -1,3 +1,5 @@
+// +build !purego
+
 #include "sequence_c.h"
 #include "sequence.hpp"
 
@@ -58,8 +60,8 @@ extern "C" {
         return get(map).contains(key);
     }
 
-    uint32_t store_memory_size(store map) {
-        uint32_t sum = 0;
+    uint64_t store_memory_size(store map) {
+        uint64_t sum = 0;
         for(auto&& value : get(map).get_values()) {
             sum += 32 + value.memory_size();
         }
@@ -21,7 +21,7 @@ extern "C" {
 
     int store_contains(store map, uint32_t key);
 
-    uint32_t store_memory_size(store map);
+    uint64_t store_memory_size(store map);
 
     struct byte_view store_get(store, uint32_t key);
 
@@ -1,62 +1,171 @@
 package store
 
 import (
+	"runtime"
+	"sync"
+
 	"github.com/cznic/sortutil"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
 type Hasher func(string) uint32
 
+// Number of pushed items that are sent to a shard at once.
+const builderBatchSize = 4096
+
+type pushedItem struct {
+	key  uint32
+	item int32
+}
+
+// Collects the items of a subset of the keys in its own goroutine.
+type builderShard struct {
+	batches chan []pushedItem
+	pending []pushedItem
+	store   *iterStore
+	done    chan struct{}
+}
+
+func newBuilderShard() *builderShard {
+	shard := &builderShard{
+		batches: make(chan []pushedItem, 4),
+		pending: make([]pushedItem, 0, builderBatchSize),
+		store:   &iterStore{NewByteStore()},
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(shard.done)
+
+		for batch := range shard.batches {
+			for _, pushed := range batch {
+				shard.store.PushInt(pushed.key, pushed.item)
+			}
+		}
+	}()
+
+	return shard
+}
+
+func (shard *builderShard) push(key uint32, item int32) {
+	shard.pending = append(shard.pending, pushedItem{key, item})
+	if len(shard.pending) >= builderBatchSize {
+		shard.batches <- shard.pending
+		shard.pending = make([]pushedItem, 0, builderBatchSize)
+	}
+}
+
+// Sends the remaining items and waits for the shard to store them.
+func (shard *builderShard) finish() {
+	if len(shard.pending) > 0 {
+		shard.batches <- shard.pending
+		shard.pending = nil
+	}
+
+	close(shard.batches)
+	<-shard.done
+}
+
+// Builds a store from pushed words. The keys are partitioned across shards
+// that collect and encode their keys in parallel. Build must be called
+// exactly once to release the goroutines of the shards.
 type StoreBuilder struct {
 	ShowProgress bool
 	hasher       Hasher
-	byteStore    ByteStore
-	iterStore    *iterStore
+	allKey       uint32
+	lastItemId   int32
+	shards       []*builderShard
 }
 
 func NewStoreBuilder(hasher Hasher) *StoreBuilder {
-	byteStore := NewByteStore()
+	return NewShardedStoreBuilder(hasher, runtime.NumCPU())
+}
+
+func NewShardedStoreBuilder(hasher Hasher, shardCount int) *StoreBuilder {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+
+	shards := make([]*builderShard, shardCount)
+	for idx := range shards {
+		shards[idx] = newBuilderShard()
+	}
+
 	return &StoreBuilder{
-		hasher:    hasher,
-		byteStore: byteStore,
-		iterStore: &iterStore{byteStore},
+		hasher: hasher,
+		allKey: hasher(AllTerm),
+		shards: shards,
 	}
 }
 
 func (sb *StoreBuilder) Push(word string, itemId int32) {
-	hash := sb.hasher(word)
+	sb.push(sb.hasher(word), itemId)
 
-	// check if the hash is alredy known before adding it
-	known := sb.byteStore.Contains(hash)
+	// add item virtual tag "__all", remaining duplicates are removed in Build.
+	if itemId != sb.lastItemId {
+		sb.push(sb.allKey, itemId)
+		sb.lastItemId = itemId
+	}
+}
 
-	sb.iterStore.PushInt(hash, int32(itemId))
+func (sb *StoreBuilder) push(key uint32, itemId int32) {
+	sb.shards[key%uint32(len(sb.shards))].push(key, itemId)
+}
 
-	if !known {
-		// add item virtual tag "__all"
-		sb.iterStore.PushInt(0, int32(itemId))
-	}
+type encodedKey struct {
+	key   uint32
+	codec SequenceCodec
+	bytes []byte
 }
 
 func (sb *StoreBuilder) Build() IterStore {
+	keyCount := 0
+	for _, shard := range sb.shards {
+		shard.finish()
+		keyCount += int(shard.store.KeyCount())
+	}
+
 	var bar *pb.ProgressBar
 	if sb.ShowProgress {
-		bar = pb.StartNew(int(sb.iterStore.KeyCount()))
+		bar = pb.StartNew(keyCount)
 		bar.ShowFinalTime = true
 		defer bar.Finish()
 	}
 
-	optimizedStore := NewIterStore(sb.byteStore)
-	for _, key := range sb.iterStore.Keys() {
-		if bar != nil {
-			bar.Increment()
-		}
+	// sort, dedupe and encode the keys of each shard in parallel
+	encoded := make([][]encodedKey, len(sb.shards))
+
+	var wg sync.WaitGroup
+	for idx, shard := range sb.shards {
+		wg.Add(1)
+
+		go func(idx int, shard *builderShard) {
+			defer wg.Done()
+
+			for _, key := range shard.store.Keys() {
+				items := IteratorToList(nil, shard.store.GetIterator(key))
+				n := sortutil.Dedupe(sortutil.Int32Slice(items))
 
-		// get the list of items
-		items := IteratorToList(nil, sb.iterStore.GetIterator(key))
-		n := sortutil.Dedupe(sortutil.Int32Slice(items))
+				codec, bytes := EncodeOptimal(items[:n], DefaultCodecPolicy)
+				encoded[idx] = append(encoded[idx], encodedKey{key, codec, bytes})
 
-		// and optimize it
-		optimizedStore.Replace(key, items[:n])
+				// release the memory of the unsorted items
+				shard.store.Remove(key)
+
+				if bar != nil {
+					bar.Increment()
+				}
+			}
+		}(idx, shard)
+	}
+
+	wg.Wait()
+
+	optimizedStore := &iterStore{NewByteStore()}
+	for _, keys := range encoded {
+		for _, encodedKey := range keys {
+			optimizedStore.replaceEncoded(encodedKey.key, encodedKey.codec, encodedKey.bytes)
+		}
 	}
 
 	return optimizedStore
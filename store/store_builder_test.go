@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cznic/sortutil"
+)
+
+func testHasher(word string) uint32 {
+	return uint32(len(word))
+}
+
+func TestStoreBuilderKeepsIdsBeyond24Bit(t *testing.T) {
+	ids := []int32{-1 << 31, -20000000, -1 << 24, -(1 << 23) - 1, -1 << 23, -(1 << 23) + 1, -1}
+
+	builder := NewStoreBuilder(testHasher)
+	for idx := len(ids) - 1; idx >= 0; idx-- {
+		builder.Push("a", ids[idx])
+	}
+
+	testIter(t, iter(ids...), builder.Build().GetIterator(1))
+}
+
+func TestInt24CodecRejectsValuesOutOfRange(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a value that does not fit into 24 bits")
+		}
+	}()
+
+	int24CodecInstance.Encode([]int32{-(1 << 23) - 1})
+}
+
+func TestReadCheckpointUnwrapsInt24Values(t *testing.T) {
+	// ids 8388609 and 8388610 wrapped into positive values.
+	wrapped := []int32{-8388608, -5, 8388606, 8388607}
+
+	source := NewIterStore(newGoByteStore())
+	source.Replace(1, wrapped)
+
+	var buffer bytes.Buffer
+	if err := WriteCheckpoint(&buffer, StoreState{}, source, NewDictionary(testHasher), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// patch the version in the json state back to zero
+	checkpoint := bytes.Replace(buffer.Bytes(), []byte(`"Version":3`), []byte(`"Version":0`), 1)
+
+	var state StoreState
+	target := NewIterStore(newGoByteStore())
+	if err := ReadCheckpoint(bytes.NewReader(checkpoint), &state, target, NewDictionary(testHasher), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Version != CurrentCheckpointVersion {
+		t.Errorf("Expected state version %d after migration, got %d", CurrentCheckpointVersion, state.Version)
+	}
+
+	testIter(t, iter(-8388610, -8388609, -8388608, -5), target.GetIterator(1))
+}
+
+func TestReadCheckpointKeepsCurrentVersion(t *testing.T) {
+	source := NewIterStore(newGoByteStore())
+	source.Replace(1, []int32{-20000000, -5})
+
+	var buffer bytes.Buffer
+	if err := WriteCheckpoint(&buffer, StoreState{}, source, NewDictionary(testHasher), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var state StoreState
+	target := NewIterStore(newGoByteStore())
+	if err := ReadCheckpoint(&buffer, &state, target, NewDictionary(testHasher), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testIter(t, iter(-20000000, -5), target.GetIterator(1))
+}
+
+func TestShardedStoreBuilderMatchesSingleThreadedOutput(t *testing.T) {
+	hasher := func(word string) uint32 { return uint32(word[0]) }
+
+	words := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+
+	// the expected store is built by deduplicating and replacing each key.
+	values := make(map[uint32][]int32)
+
+	push := func(builders []*StoreBuilder, word string, itemId int32) {
+		for _, builder := range builders {
+			builder.Push(word, itemId)
+		}
+
+		values[hasher(word)] = append(values[hasher(word)], itemId)
+		values[hasher(AllTerm)] = append(values[hasher(AllTerm)], itemId)
+	}
+
+	builders := []*StoreBuilder{
+		NewShardedStoreBuilder(hasher, 1),
+		NewShardedStoreBuilder(hasher, 3),
+		NewShardedStoreBuilder(hasher, 8),
+	}
+
+	for itemId := int32(-20000); itemId < 0; itemId++ {
+		for idx, word := range words {
+			if int(-itemId)%(idx+1) == 0 {
+				push(builders, word, itemId)
+			}
+		}
+
+		// duplicated pushes must be removed.
+		if itemId%7 == 0 {
+			push(builders, "a", itemId)
+		}
+	}
+
+	expected := NewIterStore(newGoByteStore())
+	for key, items := range values {
+		n := sortutil.Dedupe(sortutil.Int32Slice(items))
+		expected.Replace(key, items[:n])
+	}
+
+	for _, builder := range builders {
+		actual := builder.Build()
+
+		expectedKeys, actualKeys := expected.Keys(), actual.Keys()
+		if len(expectedKeys) != len(actualKeys) {
+			t.Fatalf("Expected %d keys, got %d", len(expectedKeys), len(actualKeys))
+		}
+
+		for _, key := range expectedKeys {
+			if !bytes.Equal(expected.(*iterStore).Get(key), actual.(*iterStore).Get(key)) {
+				t.Errorf("Encoded values of key %d differ with %d shards", key, len(builder.shards))
+			}
+		}
+	}
+}
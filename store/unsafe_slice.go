@@ -0,0 +1,34 @@
+package store
+
+import (
+	"unsafe"
+)
+
+// Creates a byte slice of the given length that points to the memory at ptr.
+// The caller is responsible for keeping the memory alive.
+func unsafeBytes(ptr unsafe.Pointer, length int) []byte {
+	if length == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(ptr), length)
+}
+
+// Reinterprets the bytes as a slice of int32 values in native byte order.
+// Trailing bytes that do not form a complete value are ignored.
+func bytesAsInt32s(bytes []byte) []int32 {
+	if len(bytes) < 4 {
+		return nil
+	}
+
+	return unsafe.Slice((*int32)(unsafe.Pointer(&bytes[0])), len(bytes)/4)
+}
+
+// Reinterprets the values as a slice of bytes in native byte order.
+func int32sAsBytes(values []int32) []byte {
+	if len(values) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&values[0])), 4*len(values))
+}
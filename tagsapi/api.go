@@ -14,6 +14,13 @@ type SearchResult struct {
 	Items    []int32 `json:"items"`
 }
 
+type ItemTags struct {
+	Id          int      `json:"id"`
+	Terms       []string `json:"terms"`
+	Attributes  []string `json:"attributes"`
+	UnknownKeys []uint32 `json:"unknownKeys,omitempty"`
+}
+
 type HttpClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
@@ -1,25 +1,28 @@
 package main
 
 import (
-	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/cznic/sortutil"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/mopsalarm/go-pr0gramm-tags/store"
 	log "github.com/sirupsen/logrus"
 )
 
 type tagInfo struct {
-	Id     int    `db:"id"`
-	ItemId int    `db:"item_id"`
-	Tag    string `db:"tag"`
+	Id         int     `db:"id" json:"id"`
+	ItemId     int     `db:"item_id" json:"itemId"`
+	Tag        string  `db:"tag" json:"tag"`
+	Confidence float64 `db:"confidence" json:"confidence"`
 }
 
 func queryTags(db *sqlx.DB, firstTagId, count int, consumer func(tagInfo)) error {
 	var tagInfos []tagInfo
 	err := db.Select(&tagInfos,
-		"SELECT id, item_id, lower(tag) as tag FROM tags WHERE id >= $1 ORDER BY id ASC LIMIT $2",
+		"SELECT id, item_id, lower(tag) as tag, confidence FROM tags WHERE id >= $1 ORDER BY id ASC LIMIT $2",
 		firstTagId, count)
 
 	if err == nil {
@@ -31,25 +34,192 @@ func queryTags(db *sqlx.DB, firstTagId, count int, consumer func(tagInfo)) error
 	return err
 }
 
+// Deleted tags are read from a tombstone table, that is filled by a trigger
+// when a row is deleted from the tags table:
+//
+//	CREATE TABLE tags_deleted (id SERIAL PRIMARY KEY, tag_id INT, item_id INT, tag TEXT);
+func queryDeletedTags(db *sqlx.DB, lastDeletedId, count int, consumer func(tagInfo)) error {
+	var tagInfos []tagInfo
+	err := db.Select(&tagInfos,
+		"SELECT id, item_id, lower(tag) as tag FROM tags_deleted WHERE id > $1 ORDER BY id ASC LIMIT $2",
+		lastDeletedId, count)
+
+	if err == nil {
+		for _, tagInfo := range tagInfos {
+			consumer(tagInfo)
+		}
+	}
+
+	return err
+}
+
+// Confidence changes of existing tags are read from a table, that is filled by
+// a trigger when the confidence of a row in the tags table changes:
+//
+//	CREATE TABLE tags_confidence (id SERIAL PRIMARY KEY, tag_id INT, item_id INT, tag TEXT, confidence REAL);
+func queryTagConfidences(db *sqlx.DB, lastId, count int, consumer func(tagInfo)) error {
+	var tagInfos []tagInfo
+	err := db.Select(&tagInfos,
+		"SELECT id, item_id, lower(tag) as tag, confidence FROM tags_confidence WHERE id > $1 ORDER BY id ASC LIMIT $2",
+		lastId, count)
+
+	if err == nil {
+		for _, tagInfo := range tagInfos {
+			consumer(tagInfo)
+		}
+	}
+
+	return err
+}
+
+type deletedItemInfo struct {
+	Id     int `db:"id"`
+	ItemId int `db:"item_id"`
+}
+
+// Deleted posts are read from a table that is filled when a post is deleted:
+//
+//	CREATE TABLE items_deleted (id SERIAL PRIMARY KEY, item_id INT);
+func queryDeletedItems(db *sqlx.DB, lastDeletedId, count int, consumer func(deletedItemInfo)) error {
+	var infos []deletedItemInfo
+	err := db.Select(&infos,
+		"SELECT id, item_id FROM items_deleted WHERE id > $1 ORDER BY id ASC LIMIT $2",
+		lastDeletedId, count)
+
+	if err == nil {
+		for _, info := range infos {
+			consumer(info)
+		}
+	}
+
+	return err
+}
+
+// Returns the indexed terms of all current tags of the given items.
+func queryItemTerms(db *sqlx.DB, itemIds []int) (map[int32][]string, error) {
+	var tagInfos []tagInfo
+	err := db.Select(&tagInfos,
+		"SELECT id, item_id, lower(tag) as tag, confidence FROM tags WHERE item_id = ANY($1)",
+		pq.Array(itemIds))
+
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make(map[int32][]string)
+	for _, info := range tagInfos {
+		itemId := int32(-info.ItemId)
+		terms[itemId] = append(terms[itemId], indexedTagTerms(info)...)
+	}
+
+	return terms, nil
+}
+
+// Returns the words of a tag and the terms that are derived from it.
+func tagTerms(info tagInfo) []string {
+	return append(ExtractWords(info.Tag), derivationRules.TagTerms(info)...)
+}
+
+// Prefix of the words of tags with a confidence below the threshold.
+const lowConfidencePrefix = "lc:"
+
+// Tags with a confidence below the threshold are not indexed, set at startup.
+// The bound is inclusive, with the default of zero all tags are indexed.
+var tagConfidenceThreshold float64
+
+// Index the words of tags below the threshold using the low confidence prefix, set at startup.
+var indexLowConfidenceTags bool
+
+// Returns the words of a low confidence tag using the low confidence prefix.
+func lowConfidenceTerms(info tagInfo) []string {
+	var terms []string
+	for _, word := range ExtractWords(info.Tag) {
+		terms = append(terms, lowConfidencePrefix+word)
+	}
+
+	return terms
+}
+
+// Returns the terms of a tag that are indexed for its current confidence.
+func indexedTagTerms(info tagInfo) []string {
+	switch {
+	case info.Confidence >= tagConfidenceThreshold:
+		return tagTerms(info)
+
+	case indexLowConfidenceTags:
+		return lowConfidenceTerms(info)
+
+	default:
+		return nil
+	}
+}
+
+// Returns all terms a tag might be indexed with, independent of its confidence.
+func possibleTagTerms(info tagInfo) []string {
+	terms := tagTerms(info)
+	if indexLowConfidenceTags {
+		terms = append(terms, lowConfidenceTerms(info)...)
+	}
+
+	return terms
+}
+
+// Returns true, if the term has a prefix and is not a cleaned word of a tag.
+func hasTermPrefix(term string) bool {
+	return len(term) >= 2 && term[1] == ':' || strings.HasPrefix(term, lowConfidencePrefix)
+}
+
+// Returns the terms of deleted tags that are not provided by
+// any of the remaining tags of the same item.
+func staleTagTerms(deleted, remaining map[int32][]string) map[int32][]string {
+	stale := make(map[int32][]string)
+	for itemId, terms := range deleted {
+		provided := make(map[string]bool)
+		for _, term := range remaining[itemId] {
+			provided[term] = true
+		}
+
+		for _, term := range terms {
+			if !provided[term] {
+				stale[itemId] = append(stale[itemId], term)
+				provided[term] = true
+			}
+		}
+	}
+
+	return stale
+}
+
 type postInfo struct {
-	Id            int       `db:"id"`
-	Updated       time.Time `db:"updated"`
-	Flags         int       `db:"flags"`
-	Score         int       `db:"score"`
-	CreatedEpoch  int       `db:"created"`
-	Promoted      bool      `db:"promoted"`
-	Username      string    `db:"username"`
-	UserMark      int       `db:"mark"`
-	HasText       bool      `db:"has_text"`
-	HasAudio      bool      `db:"audio"`
-	Width         int       `db:"width"`
-	Controversial bool      `db:"is_controversial"`
-}
-
-func queryItems(db *sqlx.DB, updatedAfter time.Time, days, itemCount int, consumer func(postInfo)) error {
-	var postInfos []postInfo
+	Id            int       `db:"id" json:"id"`
+	Updated       time.Time `db:"updated" json:"updated"`
+	Flags         int       `db:"flags" json:"flags"`
+	Score         int       `db:"score" json:"score"`
+	CreatedEpoch  int       `db:"created" json:"created"`
+	Promoted      bool      `db:"promoted" json:"promoted"`
+	Username      string    `db:"username" json:"username"`
+	UserMark      int       `db:"mark" json:"mark"`
+	HasText       bool      `db:"has_text" json:"hasText"`
+	HasAudio      bool      `db:"audio" json:"audio"`
+	Width         int       `db:"width" json:"width"`
+	Controversial bool      `db:"is_controversial" json:"controversial"`
+}
 
-	err := db.Select(&postInfos, `
+// Position of the item updates, items are ordered by update time and id.
+type itemCursor struct {
+	Updated time.Time
+	Id      int
+}
+
+func (cursor itemCursor) Before(postInfo postInfo) bool {
+	return cursor.Updated.Before(postInfo.Updated) ||
+		cursor.Updated.Equal(postInfo.Updated) && cursor.Id < postInfo.Id
+}
+
+type itemQuery func(after itemCursor, itemCount int, consumer func(postInfo)) error
+
+// Selects the columns of postInfo from the items table.
+const selectItems = `
 		SELECT
 			items.id,
 			items.updated,
@@ -65,8 +235,15 @@ func queryItems(db *sqlx.DB, updatedAfter time.Time, days, itemCount int, consum
 			up>60 AND down>60 AND least(up, down)::float/greatest(up, down)>=0.7 as is_controversial
 		FROM
 			items
-			LEFT JOIN items_text texts ON (items.id = texts.item_id)
-		WHERE items.updated >= $1 ORDER BY items.updated ASC LIMIT $2`, updatedAfter, itemCount)
+			LEFT JOIN items_text texts ON (items.id = texts.item_id)`
+
+// Queries the items behind the cursor.
+func queryItems(db *sqlx.DB, after itemCursor, itemCount int, consumer func(postInfo)) error {
+	var postInfos []postInfo
+
+	err := db.Select(&postInfos, selectItems+`
+		WHERE (items.updated, items.id) > ($1, $2)
+		ORDER BY items.updated ASC, items.id ASC LIMIT $3`, after.Updated, after.Id, itemCount)
 
 	if err == nil {
 		for _, postInfo := range postInfos {
@@ -77,130 +254,131 @@ func queryItems(db *sqlx.DB, updatedAfter time.Time, days, itemCount int, consum
 	return err
 }
 
-func sizeCategories(width int) []string {
-	switch {
-	case width > 3800:
-		return []string{"2160p", "4k"}
+// Returns true, if the term is derived from the item row.
+func isItemDerived(term string) bool {
+	return derivationRules.IsItemDerived(term)
+}
 
-	case width > 1900:
-		return []string{"1080p", "hd"}
+// Returns all terms that are derived from the item row.
+func derivedTerms(postInfo postInfo) []string {
+	return derivationRules.ItemTerms(postInfo)
+}
 
-	case width > 1200:
-		return []string{"720p", "hd"}
+type updateBatch struct {
+	Updates store.IterStore
+	More    bool
 
-	case width > 600:
-		return []string{"sd"}
+	// The new state of the store, or nil to keep the current state.
+	State *store.StoreState
 
-	default:
-		return []string{"kartoffel"}
-	}
+	// The sorted keys of the item derived terms of each updated item.
+	ItemKeys map[int32][]uint32
+
+	// Terms of deleted tags that need to be removed from the items.
+	RemovedTerms map[int32][]string
+
+	// Sorted ids of deleted items that need to be removed from all keys.
+	DeletedItems []int32
 }
 
-func userMarkToString(mark int) string {
-	switch mark {
-	case 6:
-		return "ftb"
-	case 1:
-		return "newfag"
-	default:
-		return ""
+// Pushes the derived terms of the item into the builder and records their keys.
+func addItem(builder *store.StoreBuilder, dict *store.Dictionary, itemKeys map[int32][]uint32, postInfo postInfo) {
+	itemId := int32(-postInfo.Id)
+
+	var keys []uint32
+	for _, term := range derivedTerms(postInfo) {
+		builder.Push(term, itemId)
+		keys = append(keys, dict.KeyOf(term))
 	}
+
+	sort.Sort(sortutil.Uint32Slice(keys))
+	itemKeys[itemId] = keys[:sortutil.Dedupe(sortutil.Uint32Slice(keys))]
 }
 
-func FetchUpdates(db *sqlx.DB, state store.StoreState) (store.IterStore, store.StoreState, bool) {
-	builder := store.NewStoreBuilder(HashWord)
+// Changes of items and tags read from an update source.
+type changeBatch struct {
+	Items []postInfo
+	Tags  []tagInfo
 
-	itemCount := 10000
-	{
-		days := 3
-		err := queryItems(db, state.LastItemUpdateTime, days, itemCount, func(postInfo postInfo) {
-			itemId := int32(-postInfo.Id)
-
-			// Prefixes currently in-use:
-			//  d: date
-			//  f: flags
-			//  s: score
-			//  u: user
-			//  q: quality
-			//  m: mark (ftb, newfag)
-
-			builder.Push("u:"+CleanString(postInfo.Username), itemId)
-
-			switch {
-			case postInfo.Flags&1 != 0:
-				builder.Push("f:sfw", itemId)
-			case postInfo.Flags&2 != 0:
-				builder.Push("f:nsfw", itemId)
-			case postInfo.Flags&4 != 0:
-				builder.Push("f:nsfl", itemId)
-			case postInfo.Flags&8 != 0:
-				builder.Push("f:nsfp", itemId)
-			}
+	// Terms of deleted tags that are not provided by another tag of the same item.
+	RemovedTerms map[int32][]string
 
-			if postInfo.Promoted {
-				builder.Push("f:top", itemId)
-			}
+	// Ids of deleted items.
+	DeletedItems []int
 
-			if postInfo.HasText {
-				builder.Push("f:text", itemId)
-			}
+	// The state with the cursor behind this batch.
+	State store.StoreState
 
-			if postInfo.HasAudio {
-				builder.Push("f:sound", itemId)
-			}
+	// True, if the source expects more changes to be fetched right away.
+	More bool
+}
 
-			if postInfo.Controversial {
-				builder.Push("f:controversial", itemId)
-			}
+// A source of item and tag changes.
+type UpdateSource interface {
+	// Fetches the next batch of changes behind the cursor in the given state.
+	FetchChanges(state store.StoreState) (changeBatch, error)
+}
 
-			// mark content of ftp and newfags special.
-			if mark := userMarkToString(postInfo.UserMark); mark != "" {
-				builder.Push("m:"+mark, itemId)
-			}
+// Converts a batch of changes into updates for the store.
+func buildUpdates(dict *store.Dictionary, changes changeBatch) updateBatch {
+	builder := store.NewStoreBuilder(dict.KeyOf)
+	itemKeys := make(map[int32][]uint32)
 
-			// add quality-tag
-			for _, sizeCategory := range sizeCategories(postInfo.Width) {
-				builder.Push("q:"+sizeCategory, itemId)
-			}
+	for _, postInfo := range changes.Items {
+		addItem(builder, dict, itemKeys, postInfo)
+	}
 
-			// date tags
-			created := time.Unix(int64(postInfo.CreatedEpoch), 0)
-			builder.Push(fmt.Sprintf("d:%04d", created.Year()), itemId)
-			builder.Push(fmt.Sprintf("d:%04d:%02d", created.Year(), created.Month()), itemId)
+	for _, info := range changes.Tags {
+		itemId := int32(-info.ItemId)
+		for _, term := range indexedTagTerms(info) {
+			builder.Push(term, itemId)
+		}
+	}
 
-			// sort posts into bins (size 100) by score.
-			// a post with score 1125 will be put into bins 100, 200,... and 1100
-			for bin := 1; bin <= postInfo.Score/100; bin++ {
-				label := fmt.Sprintf("s:%d", (100 * bin))
-				builder.Push(label, itemId)
-			}
+	deletedItems := make([]int32, len(changes.DeletedItems))
+	for idx, itemId := range changes.DeletedItems {
+		deletedItems[idx] = int32(-itemId)
+	}
 
-			// add a label for the real shitty content.
-			if postInfo.Score < -300 {
-				builder.Push("s:shit", itemId)
-			}
+	sort.Sort(sortutil.Int32Slice(deletedItems))
+	deletedItems = deletedItems[:sortutil.Dedupe(sortutil.Int32Slice(deletedItems))]
 
-			itemCount -= 1
-			state.LastItemUpdateTime = postInfo.Updated
-		})
+	return updateBatch{
+		Updates:      builder.Build(),
+		State:        &changes.State,
+		More:         changes.More,
+		ItemKeys:     itemKeys,
+		RemovedTerms: changes.RemovedTerms,
+		DeletedItems: deletedItems,
+	}
+}
 
-		if err != nil {
-			log.WithError(err).Warn("Could not fetch the list of post items")
-			metricsUpdaterError.Inc(1)
-		}
+// Reads changes from the pr0gramm postgres database.
+type postgresSource struct {
+	db *sqlx.DB
+}
+
+func (source postgresSource) FetchChanges(state store.StoreState) (changeBatch, error) {
+	db := source.db
+
+	var changes changeBatch
+
+	query := func(after itemCursor, itemCount int, consumer func(postInfo)) error {
+		return queryItems(db, after, itemCount, consumer)
+	}
+
+	items, moreItems, err := fetchItems(query, &state, 10000)
+	if err != nil {
+		log.WithError(err).Warn("Could not fetch the list of post items")
+		metricsUpdaterError.Inc(1)
 	}
 
+	changes.Items = items
+
 	tagCount := 50000
 	{
 		err := queryTags(db, state.LastTagId, tagCount, func(info tagInfo) {
-			itemId := int32(-info.ItemId)
-			for _, word := range ExtractWords(info.Tag) {
-				builder.Push(word, itemId)
-			}
-
-			if strings.ToLower(info.Tag) == "repost" {
-				builder.Push("f:repost", itemId)
-			}
+			changes.Tags = append(changes.Tags, info)
 
 			tagCount -= 1
 			state.LastTagId = info.Id
@@ -212,6 +390,140 @@ func FetchUpdates(db *sqlx.DB, state store.StoreState) (store.IterStore, store.S
 		}
 	}
 
-	expectMore := tagCount == 0 || itemCount == 0
-	return builder.Build(), state, expectMore
+	removedTerms, moreDeletedTags, err := fetchDeletedTags(db, &state, 10000)
+	if err != nil {
+		log.WithError(err).Warn("Could not fetch deleted tags")
+		metricsUpdaterError.Inc(1)
+	}
+
+	changes.RemovedTerms = removedTerms
+
+	changedTags, changedTerms, moreConfidences, err := fetchTagConfidences(db, &state, 10000)
+	if err != nil {
+		log.WithError(err).Warn("Could not fetch changed tag confidences")
+		metricsUpdaterError.Inc(1)
+	}
+
+	changes.Tags = append(changes.Tags, changedTags...)
+	changes.RemovedTerms = mergeTerms(changes.RemovedTerms, changedTerms)
+
+	deletedItemCount := 10000
+	{
+		err := queryDeletedItems(db, state.LastDeletedItemId, deletedItemCount, func(info deletedItemInfo) {
+			changes.DeletedItems = append(changes.DeletedItems, info.ItemId)
+
+			deletedItemCount -= 1
+			state.LastDeletedItemId = info.Id
+		})
+
+		if err != nil {
+			log.WithError(err).Warn("Could not fetch deleted items")
+			metricsUpdaterError.Inc(1)
+		}
+	}
+
+	// errors are logged above, the cursor of each table is only moved
+	// forward for the rows that were read.
+	changes.State = state
+	changes.More = tagCount == 0 || moreItems || moreDeletedTags || moreConfidences || deletedItemCount == 0
+	return changes, nil
+}
+
+// Reads deleted tags and returns the terms that need to be removed from the items.
+// The state is only updated, if all deleted tags could be processed. Returns true
+// if there might be more deleted tags to read.
+func fetchDeletedTags(db *sqlx.DB, state *store.StoreState, count int) (map[int32][]string, bool, error) {
+	lastDeletedTagId := state.LastDeletedTagId
+
+	deleted := make(map[int32][]string)
+	var itemIds []int
+
+	remainingCount := count
+	err := queryDeletedTags(db, lastDeletedTagId, count, func(info tagInfo) {
+		itemId := int32(-info.ItemId)
+		if deleted[itemId] == nil {
+			itemIds = append(itemIds, info.ItemId)
+		}
+
+		deleted[itemId] = append(deleted[itemId], possibleTagTerms(info)...)
+		lastDeletedTagId = info.Id
+		remainingCount--
+	})
+
+	if err != nil || len(deleted) == 0 {
+		return nil, false, err
+	}
+
+	remaining, err := queryItemTerms(db, itemIds)
+	if err != nil {
+		return nil, false, err
+	}
+
+	state.LastDeletedTagId = lastDeletedTagId
+	return staleTagTerms(deleted, remaining), remainingCount == 0, nil
+}
+
+// Reads tags with a changed confidence. The tags are returned to index them
+// using their new confidence, the terms they were indexed with before are
+// returned to be removed, if no other tag of the item provides them. The state
+// is only updated, if all changed tags could be processed. Returns true if
+// there might be more changes to read.
+func fetchTagConfidences(db *sqlx.DB, state *store.StoreState, count int) ([]tagInfo, map[int32][]string, bool, error) {
+	lastId := state.LastTagConfidenceId
+
+	var tags []tagInfo
+	previous := make(map[int32][]string)
+	var itemIds []int
+
+	err := queryTagConfidences(db, lastId, count, func(info tagInfo) {
+		itemId := int32(-info.ItemId)
+		if previous[itemId] == nil {
+			itemIds = append(itemIds, info.ItemId)
+		}
+
+		// the terms of the new confidence are provided by the remaining tags.
+		previous[itemId] = append(previous[itemId], possibleTagTerms(info)...)
+		tags = append(tags, info)
+		lastId = info.Id
+	})
+
+	if err != nil || len(tags) == 0 {
+		return nil, nil, false, err
+	}
+
+	remaining, err := queryItemTerms(db, itemIds)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	state.LastTagConfidenceId = lastId
+	return tags, staleTagTerms(previous, remaining), len(tags) == count, nil
+}
+
+// Merges the terms of both maps into a new map.
+func mergeTerms(first, second map[int32][]string) map[int32][]string {
+	merged := make(map[int32][]string)
+	for _, terms := range []map[int32][]string{first, second} {
+		for itemId, itemTerms := range terms {
+			merged[itemId] = append(merged[itemId], itemTerms...)
+		}
+	}
+
+	return merged
+}
+
+// Reads the items behind the item cursor of the state and moves the cursor to the
+// last item read. Returns true, if there might be more items to read.
+func fetchItems(query itemQuery, state *store.StoreState, itemCount int) ([]postInfo, bool, error) {
+	cursor := itemCursor{Updated: state.LastItemUpdateTime, Id: state.LastItemId}
+
+	var items []postInfo
+	err := query(cursor, itemCount, func(postInfo postInfo) {
+		items = append(items, postInfo)
+
+		state.LastItemUpdateTime = postInfo.Updated
+		state.LastItemId = postInfo.Id
+	})
+
+	return items, len(items) == itemCount, err
 }
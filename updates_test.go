@@ -0,0 +1,422 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mopsalarm/go-pr0gramm-tags/store"
+)
+
+func newTestActions() *storeActions {
+	return &storeActions{
+		store: store.NewIterStore(nil),
+		dict:  store.NewDictionary(HashWord),
+	}
+}
+
+// Applies an update batch containing the given items.
+func applyItems(sa *storeActions, postInfos ...postInfo) {
+	builder := store.NewStoreBuilder(sa.dict.KeyOf)
+	itemKeys := make(map[int32][]uint32)
+	for _, postInfo := range postInfos {
+		addItem(builder, sa.dict, itemKeys, postInfo)
+	}
+
+	sa.applyUpdates(updateBatch{Updates: builder.Build(), ItemKeys: itemKeys})
+}
+
+func hasItem(sa *storeActions, term string, itemId int) bool {
+	for _, item := range store.IteratorToList(nil, sa.termIterator(term)) {
+		if item == int32(-itemId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func testPostInfo(id int) postInfo {
+	return postInfo{
+		Id:           id,
+		Flags:        1,
+		Score:        150,
+		CreatedEpoch: int(time.Date(2017, 5, 10, 12, 0, 0, 0, time.Local).Unix()),
+		Username:     "cha0s",
+		Width:        1000,
+	}
+}
+
+func TestDerivedTerms(t *testing.T) {
+	postInfo := testPostInfo(1)
+	postInfo.Promoted = true
+	postInfo.HasText = true
+	postInfo.HasAudio = true
+	postInfo.Controversial = true
+	postInfo.UserMark = 6
+
+	expected := []string{
+		"u:cha0s", "f:sfw", "f:top", "f:text", "f:sound", "f:controversial",
+		"m:ftb", "q:sd", "d:2017", "d:2017:05", "s:100"}
+
+	terms := derivedTerms(postInfo)
+	if len(terms) != len(expected) {
+		t.Fatalf("Expected terms %v, got %v", expected, terms)
+	}
+
+	for idx := range expected {
+		if terms[idx] != expected[idx] {
+			t.Errorf("Expected term %s at index %d, got %s", expected[idx], idx, terms[idx])
+		}
+
+		if !isItemDerived(terms[idx]) {
+			t.Errorf("Term %s must be item derived", terms[idx])
+		}
+	}
+
+	if isItemDerived("f:repost") || isItemDerived("kadse") {
+		t.Error("Terms from tags must not be item derived")
+	}
+}
+
+func TestUpdateRemovesStaleDerivedKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    func(p *postInfo)
+		staleTerm string
+		newTerm   string
+	}{
+		{"user", func(p *postInfo) { p.Username = "mopsalarm" }, "u:cha0s", "u:mopsalarm"},
+		{"flags", func(p *postInfo) { p.Flags = 2 }, "f:sfw", "f:nsfw"},
+		{"promoted", func(p *postInfo) { p.Promoted = false }, "f:top", "f:sfw"},
+		{"text", func(p *postInfo) { p.HasText = false }, "f:text", "f:sfw"},
+		{"sound", func(p *postInfo) { p.HasAudio = false }, "f:sound", "f:sfw"},
+		{"controversial", func(p *postInfo) { p.Controversial = false }, "f:controversial", "f:sfw"},
+		{"mark", func(p *postInfo) { p.UserMark = 1 }, "m:ftb", "m:newfag"},
+		{"quality", func(p *postInfo) { p.Width = 2000 }, "q:sd", "q:1080p"},
+		{"date", func(p *postInfo) { p.CreatedEpoch += 366 * 24 * 3600 }, "d:2017:05", "d:2018"},
+		{"score", func(p *postInfo) { p.Score = 50 }, "s:100", "f:sfw"},
+		{"shit", func(p *postInfo) { p.Score = 0 }, "s:shit", "f:sfw"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sa := newTestActions()
+
+			original := testPostInfo(1)
+			original.Promoted = true
+			original.HasText = true
+			original.HasAudio = true
+			original.Controversial = true
+			original.UserMark = 6
+			if test.name == "shit" {
+				original.Score = -400
+			}
+
+			// a second item that keeps all of its terms
+			other := original
+			other.Id = 2
+
+			applyItems(sa, original, other)
+			if !hasItem(sa, test.staleTerm, 1) {
+				t.Fatalf("Item is missing in %s before the update", test.staleTerm)
+			}
+
+			updated := original
+			test.update(&updated)
+			applyItems(sa, updated)
+
+			if hasItem(sa, test.staleTerm, 1) {
+				t.Errorf("Item is still in %s after the update", test.staleTerm)
+			}
+
+			if !hasItem(sa, test.newTerm, 1) {
+				t.Errorf("Item is missing in %s after the update", test.newTerm)
+			}
+
+			if !hasItem(sa, test.staleTerm, 2) || !hasItem(sa, store.AllTerm, 1) {
+				t.Error("Update removed an item that was not changed")
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsRepostFlag(t *testing.T) {
+	sa := newTestActions()
+
+	builder := store.NewStoreBuilder(sa.dict.KeyOf)
+	builder.Push("f:repost", -1)
+	sa.applyUpdates(updateBatch{Updates: builder.Build()})
+
+	applyItems(sa, testPostInfo(1))
+
+	if !hasItem(sa, "f:repost", 1) {
+		t.Error("An item update removed the repost flag")
+	}
+}
+
+func TestStaleTagTerms(t *testing.T) {
+	deleted := map[int32][]string{
+		-1: tagTerms(tagInfo{Tag: "original content"}),
+		-2: tagTerms(tagInfo{Tag: "repost"}),
+	}
+
+	remaining := map[int32][]string{
+		-1: tagTerms(tagInfo{Tag: "content"}),
+		-2: tagTerms(tagInfo{Tag: "kein repost"}),
+	}
+
+	stale := staleTagTerms(deleted, remaining)
+
+	if len(stale[-1]) != 1 || stale[-1][0] != "original" {
+		t.Errorf("Expected only 'original' to be removed from item 1, got %v", stale[-1])
+	}
+
+	if len(stale[-2]) != 1 || stale[-2][0] != "f:repost" {
+		t.Errorf("Expected only the repost flag to be removed from item 2, got %v", stale[-2])
+	}
+}
+
+func TestUpdateRemovesDeletedTags(t *testing.T) {
+	sa := newTestActions()
+
+	builder := store.NewStoreBuilder(sa.dict.KeyOf)
+	for _, term := range tagTerms(tagInfo{Tag: "original content"}) {
+		builder.Push(term, -1)
+		builder.Push(term, -2)
+	}
+
+	sa.applyUpdates(updateBatch{Updates: builder.Build()})
+
+	sa.applyUpdates(updateBatch{
+		Updates:      store.NewIterStore(nil),
+		RemovedTerms: map[int32][]string{-1: {"original"}},
+	})
+
+	if hasItem(sa, "original", 1) || !hasItem(sa, "content", 1) {
+		t.Error("Expected only the removed term to be removed from the item")
+	}
+
+	if !hasItem(sa, "original", 2) {
+		t.Error("Removed a term from an other item")
+	}
+}
+
+func TestUpdatePurgesDeletedItems(t *testing.T) {
+	sa := newTestActions()
+	applyItems(sa, testPostInfo(1), testPostInfo(2))
+
+	// the item is deleted and updated in the same batch
+	builder := store.NewStoreBuilder(sa.dict.KeyOf)
+	itemKeys := make(map[int32][]uint32)
+	addItem(builder, sa.dict, itemKeys, testPostInfo(1))
+
+	sa.applyUpdates(updateBatch{
+		Updates:      builder.Build(),
+		ItemKeys:     itemKeys,
+		DeletedItems: []int32{-1},
+	})
+
+	for _, term := range append(derivedTerms(testPostInfo(1)), store.AllTerm) {
+		if hasItem(sa, term, 1) {
+			t.Errorf("Deleted item is still in %s", term)
+		}
+
+		if !hasItem(sa, term, 2) {
+			t.Errorf("Other item was removed from %s", term)
+		}
+	}
+}
+
+// Simulates the item query on a table sorted by update time and id.
+func fakeItemQuery(table []postInfo) itemQuery {
+	return func(after itemCursor, itemCount int, consumer func(postInfo)) error {
+		for _, postInfo := range table {
+			if itemCount == 0 {
+				break
+			}
+
+			if after.Before(postInfo) {
+				consumer(postInfo)
+				itemCount--
+			}
+		}
+
+		return nil
+	}
+}
+
+// Fetches items until no more items are expected and returns the number of reads per item.
+func fetchAllItems(t *testing.T, query itemQuery, state *store.StoreState, itemCount int) map[int]int {
+	reads := make(map[int]int)
+	for round := 0; ; round++ {
+		if round > 100 {
+			t.Fatal("Fetching items does not terminate")
+		}
+
+		items, more, err := fetchItems(query, state, itemCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, item := range items {
+			reads[item.Id]++
+		}
+
+		if !more {
+			return reads
+		}
+	}
+}
+
+func TestFetchItemsWithTimestampTies(t *testing.T) {
+	first := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second)
+
+	// more items share a timestamp than fit into one batch
+	var table []postInfo
+	for id := 1; id <= 25; id++ {
+		table = append(table, postInfo{Id: id, Updated: first})
+	}
+
+	for id := 3; id <= 7; id++ {
+		table = append(table, postInfo{Id: id * 100, Updated: second})
+	}
+
+	var state store.StoreState
+	reads := fetchAllItems(t, fakeItemQuery(table), &state, 10)
+
+	if len(reads) != len(table) {
+		t.Errorf("Expected %d items, got %d", len(table), len(reads))
+	}
+
+	for id, count := range reads {
+		if count != 1 {
+			t.Errorf("Item %d was read %d times", id, count)
+		}
+	}
+
+	if !state.LastItemUpdateTime.Equal(second) || state.LastItemId != 700 {
+		t.Errorf("Unexpected cursor %s, %d", state.LastItemUpdateTime, state.LastItemId)
+	}
+
+	// an item updated later with a smaller id is still found
+	table = append(table, postInfo{Id: 2, Updated: second.Add(time.Second)})
+	reads = fetchAllItems(t, fakeItemQuery(table), &state, 10)
+
+	if len(reads) != 1 || reads[2] != 1 {
+		t.Errorf("Expected only the new item to be read, got %v", reads)
+	}
+}
+
+func TestFetchItemsWithStateWithoutItemId(t *testing.T) {
+	updated := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	table := []postInfo{
+		{Id: 1, Updated: updated.Add(-time.Second)},
+		{Id: 2, Updated: updated},
+		{Id: 3, Updated: updated},
+	}
+
+	// a state written before the item id was part of the cursor
+	state := store.StoreState{LastItemUpdateTime: updated}
+	reads := fetchAllItems(t, fakeItemQuery(table), &state, 10)
+
+	if len(reads) != 2 || reads[2] != 1 || reads[3] != 1 {
+		t.Errorf("Expected all items of the last update time to be read again, got %v", reads)
+	}
+}
+
+func TestUpdateIndexesTagsByConfidence(t *testing.T) {
+	tagConfidenceThreshold, indexLowConfidenceTags = 0.2, true
+	defer func() { tagConfidenceThreshold, indexLowConfidenceTags = 0, false }()
+
+	sa := newTestActions()
+
+	sa.applyUpdates(buildUpdates(sa.dict, changeBatch{Tags: []tagInfo{
+		{Id: 1, ItemId: 1, Tag: "kadse", Confidence: 0.1},
+		{Id: 2, ItemId: 1, Tag: "kefer", Confidence: 0.5},
+		{Id: 3, ItemId: 2, Tag: "kadse", Confidence: 0.3},
+	}}))
+
+	if hasItem(sa, "kadse", 1) || !hasItem(sa, "lc:kadse", 1) {
+		t.Error("Expected the low confidence tag only with the low confidence prefix")
+	}
+
+	if !hasItem(sa, "kefer", 1) || !hasItem(sa, "kadse", 2) || hasItem(sa, "lc:kadse", 2) {
+		t.Error("Expected tags above the threshold without prefix")
+	}
+
+	// the confidence of both kadse tags crosses the threshold.
+	changed := []tagInfo{
+		{Id: 1, ItemId: 1, Tag: "kadse", Confidence: 0.4},
+		{Id: 2, ItemId: 2, Tag: "kadse", Confidence: 0.1},
+	}
+
+	previous := make(map[int32][]string)
+	remaining := make(map[int32][]string)
+	for _, info := range changed {
+		previous[int32(-info.ItemId)] = possibleTagTerms(info)
+		remaining[int32(-info.ItemId)] = indexedTagTerms(info)
+	}
+
+	sa.applyUpdates(buildUpdates(sa.dict, changeBatch{
+		Tags:         changed,
+		RemovedTerms: staleTagTerms(previous, remaining),
+	}))
+
+	if !hasItem(sa, "kadse", 1) || hasItem(sa, "lc:kadse", 1) {
+		t.Error("Expected the tag to be indexed after the confidence increased")
+	}
+
+	if hasItem(sa, "kadse", 2) || !hasItem(sa, "lc:kadse", 2) {
+		t.Error("Expected the tag to be removed after the confidence decreased")
+	}
+
+	if !hasItem(sa, "kefer", 1) {
+		t.Error("Removed an unchanged tag")
+	}
+}
+
+func TestUpdateRemovesStaleDerivedKeysAfterRestart(t *testing.T) {
+	sa := newTestActions()
+
+	// a store loaded from a checkpoint, without a record of the derived keys.
+	sa.store.Replace(sa.dict.KeyOf("u:cha0s"), []int32{-2, -1})
+
+	updated := testPostInfo(1)
+	updated.Username = "mopsalarm"
+	applyItems(sa, updated)
+
+	if hasItem(sa, "u:cha0s", 1) || !hasItem(sa, "u:mopsalarm", 1) {
+		t.Error("Expected the item to move to its new user")
+	}
+
+	if !hasItem(sa, "u:cha0s", 2) {
+		t.Error("Update removed an item that was not changed")
+	}
+}
+
+func TestUpdateRemovesStaleDerivedKeysUsingForwardIndex(t *testing.T) {
+	sa := newTestActions()
+	sa.forward = store.NewForwardIndex()
+
+	original := testPostInfo(1)
+	other := testPostInfo(2)
+	applyItems(sa, original, other)
+
+	updated := original
+	updated.Flags = 2
+	applyItems(sa, updated)
+
+	if hasItem(sa, "f:sfw", 1) || !hasItem(sa, "f:nsfw", 1) {
+		t.Error("Expected the item to move from f:sfw to f:nsfw")
+	}
+
+	if !hasItem(sa, "f:sfw", 2) {
+		t.Error("Update removed an item that was not changed")
+	}
+
+	sfw, _ := sa.dict.Lookup("f:sfw")
+	if containsKey(sa.forward.Keys(-1), sfw) {
+		t.Error("Forward index still contains the stale key")
+	}
+}